@@ -7,8 +7,13 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/audit"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
 	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/history"
 	"github.com/bonnefoa/channelz/channelz-proxy/pkg/util"
 	"github.com/bonnefoa/channelz/channelz-proxy/pkg/web"
 	"github.com/gin-gonic/gin"
@@ -29,6 +34,74 @@ var (
 
 	listenAddress     string
 	testServerAddress string
+
+	upstreamTLS           bool
+	tlsCAFile             string
+	tlsCertFile           string
+	tlsKeyFile            string
+	tlsServerName         string
+	tlsTargetSecurityFile string
+
+	connCacheSize    int
+	connCacheIdleTTL time.Duration
+
+	fanOutConcurrency int
+
+	metricsTargets       string
+	metricsScrapeTimeout time.Duration
+
+	watchInterval time.Duration
+
+	historyPath      string
+	historyTargets   string
+	historyInterval  time.Duration
+	historyRetention time.Duration
+
+	discoveryEnabled        bool
+	kubeconfig              string
+	discoveryNamespace      string
+	discoverySelector       string
+	discoveryPortName       string
+	discoveryPortAnnotation string
+	discoveryInterval       time.Duration
+
+	targetsFile           string
+	targetsReloadInterval time.Duration
+
+	allowCIDRs      string
+	allowHostnames  string
+	allowPorts      string
+	allowDiscovered bool
+
+	authTokensFile     string
+	authJWKS           string
+	authJWKSRefresh    time.Duration
+	authJWTIssuer      string
+	authJWTAudience    string
+	authJWTGroupsClaim string
+	authPolicyFile     string
+
+	corsAllowedOrigins   string
+	corsAllowedHeaders   string
+	corsAllowedMethods   string
+	corsAllowCredentials bool
+	corsMaxAge           time.Duration
+
+	clientRate          float64
+	clientBurst         int
+	targetRate          float64
+	targetBurst         int
+	targetMaxConcurrent int
+	targetMaxQueue      int
+	targetQueueTimeout  time.Duration
+
+	auditLog           string
+	auditLogMaxSize    int
+	auditLogMaxBackups int
+	auditSkipRoutes    string
+
+	certificateExpiryWindow time.Duration
+	trustedProxies          string
 )
 
 func setCliFlags() {
@@ -37,16 +110,75 @@ func setCliFlags() {
 	flag.BoolVar(&httpDebug, "http-debug", false, "Activate http debug")
 	flag.StringVar(&listenAddress, "listen-address", "localhost:8080", "Address for listener")
 	flag.StringVar(&testServerAddress, "test-server-address", "", "Address for test grpc server")
+
+	flag.BoolVar(&upstreamTLS, "tls", false, "Use TLS to connect to channelz endpoints")
+	flag.StringVar(&tlsCAFile, "tls-ca", "", "CA bundle used to verify channelz endpoints, system roots are used if empty")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "Client certificate used for mTLS")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "Client key used for mTLS")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "Override the server name used to verify channelz endpoints")
+	flag.StringVar(&tlsTargetSecurityFile, "tls-target-file", "", "Yaml file with per address security configuration")
+
+	flag.IntVar(&connCacheSize, "conn-cache-size", 100, "Maximum number of cached upstream connections")
+	flag.DurationVar(&connCacheIdleTTL, "conn-idle-ttl", 10*time.Minute, "Close upstream connections unused for this duration")
+	flag.StringVar(&metricsTargets, "metrics-targets", "", "Comma separated list of addresses exported on /metrics")
+	flag.DurationVar(&metricsScrapeTimeout, "metrics-scrape-timeout", 10*time.Second, "Timeout of a channelz scrape for metrics")
+	flag.DurationVar(&watchInterval, "watch-interval", 2*time.Second, "Default poll interval of entities watched with server-sent events")
+	flag.StringVar(&historyPath, "history-path", "", "Path of the snapshot history database, history is disabled if empty")
+	flag.StringVar(&historyTargets, "history-targets", "", "Comma separated list of addresses to snapshot")
+	flag.DurationVar(&historyInterval, "history-interval", time.Minute, "Interval between two snapshots of a target")
+	flag.DurationVar(&historyRetention, "history-retention", 24*time.Hour, "Duration snapshots are kept")
+	flag.BoolVar(&discoveryEnabled, "discovery", false, "Discover channelz targets from kubernetes pods")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path of the kubeconfig file, the in-cluster configuration is used if empty")
+	flag.StringVar(&discoveryNamespace, "discovery-namespace", "", "Namespace of discovered pods, all namespaces if empty")
+	flag.StringVar(&discoverySelector, "discovery-selector", "", "Label selector of discovered pods")
+	flag.StringVar(&discoveryPortName, "discovery-port-name", discovery.DefaultPortName, "Name of the container port serving channelz")
+	flag.StringVar(&discoveryPortAnnotation, "discovery-port-annotation", discovery.DefaultPortAnnotation, "Pod annotation holding the channelz port, takes precedence over the port name")
+	flag.DurationVar(&discoveryInterval, "discovery-interval", 30*time.Second, "Interval between two pod listings")
+	flag.StringVar(&targetsFile, "targets-file", "", "Yaml file of named targets, reloaded on SIGHUP or when modified")
+	flag.DurationVar(&targetsReloadInterval, "targets-reload-interval", 10*time.Second, "Interval between two checks of the targets file modification time")
+	flag.StringVar(&allowCIDRs, "allow-cidrs", "", "Comma separated list of CIDRs the proxy may dial")
+	flag.StringVar(&allowHostnames, "allow-hostnames", "", "Comma separated list of hostname globs the proxy may dial, like *.svc.cluster.local")
+	flag.StringVar(&allowPorts, "allow-ports", "", "Comma separated list of ports or port ranges the proxy may dial, like 9000-9100")
+	flag.BoolVar(&allowDiscovered, "allow-discovered", true, "Allow registered and discovered targets regardless of the allowlist")
+	flag.StringVar(&authTokensFile, "auth-tokens-file", "", "Yaml file of static bearer tokens with their subject and groups")
+	flag.StringVar(&authJWKS, "auth-jwks", "", "File or http(s) URL of the JWKS used to verify bearer JWTs")
+	flag.DurationVar(&authJWKSRefresh, "auth-jwks-refresh", 10*time.Minute, "Interval between two JWKS reloads")
+	flag.StringVar(&authJWTIssuer, "auth-jwt-issuer", "", "Expected issuer of JWTs, not checked if empty")
+	flag.StringVar(&authJWTAudience, "auth-jwt-audience", "", "Expected audience of JWTs, not checked if empty")
+	flag.StringVar(&authJWTGroupsClaim, "auth-jwt-groups-claim", "groups", "JWT claim holding the groups of the principal")
+	flag.StringVar(&authPolicyFile, "auth-policy-file", "", "Yaml file of rules granting principals access to targets, every target is allowed if empty")
+	flag.StringVar(&corsAllowedOrigins, "cors-allowed-origins", "", "Comma separated list of origins allowed to call the api, like https://dashboard.example.com, https://*.example.com or *. Cross-origin requests are rejected if empty")
+	flag.StringVar(&corsAllowedHeaders, "cors-allowed-headers", "Authorization,Content-Type,Accept,Cache-Control", "Comma separated list of headers allowed in cross-origin requests")
+	flag.StringVar(&corsAllowedMethods, "cors-allowed-methods", "GET,POST,DELETE", "Comma separated list of methods allowed in cross-origin requests")
+	flag.BoolVar(&corsAllowCredentials, "cors-allow-credentials", false, "Allow cross-origin requests with credentials")
+	flag.DurationVar(&corsMaxAge, "cors-max-age", 10*time.Minute, "Duration browsers may cache a preflight response")
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "Comma separated list of addresses or CIDRs of proxies allowed to set the client ip with X-Forwarded-For, none if empty")
+	flag.Float64Var(&clientRate, "client-rate", 0, "Requests per second allowed to each api client, unlimited if 0")
+	flag.IntVar(&clientBurst, "client-burst", 20, "Burst of requests allowed to each api client")
+	flag.Float64Var(&targetRate, "target-rate", 0, "Upstream rpcs per second sent to each target, unlimited if 0")
+	flag.IntVar(&targetBurst, "target-burst", 50, "Burst of upstream rpcs sent to each target")
+	flag.IntVar(&targetMaxConcurrent, "target-max-concurrent", 0, "Upstream rpcs in flight to each target, unlimited if 0")
+	flag.IntVar(&targetMaxQueue, "target-max-queue", 100, "Upstream rpcs waiting for a concurrency slot of a target")
+	flag.DurationVar(&targetQueueTimeout, "target-queue-timeout", 2*time.Second, "Maximum wait of an upstream rpc for a rate token or a concurrency slot")
+	flag.StringVar(&auditLog, "audit-log", "", "Audit log of api calls written as json lines, - for stdout. Disabled if empty")
+	flag.IntVar(&auditLogMaxSize, "audit-log-max-size", 100, "Size in megabytes of the audit log before it is rotated")
+	flag.IntVar(&auditLogMaxBackups, "audit-log-max-backups", 5, "Number of rotated audit logs to keep")
+	flag.StringVar(&auditSkipRoutes, "audit-skip-routes", "/readiness", "Comma separated list of paths which are not audited")
+	flag.DurationVar(&certificateExpiryWindow, "certificate-expiry-window", 30*24*time.Hour, "Certificates expiring within this duration are flagged by /api/certificates")
+	flag.IntVar(&fanOutConcurrency, "fanout-concurrency", 16, "Maximum number of parallel upstream calls when fetching multiple subchannels or sockets")
 }
 
-func handleSignals(cancel context.CancelFunc, logger *zap.Logger) {
+func handleSignals(cancel context.CancelFunc, logger *zap.Logger, reload func()) {
 	sigIn := make(chan os.Signal, 100)
-	signal.Notify(sigIn)
+	signal.Notify(sigIn, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	for sig := range sigIn {
 		switch sig {
 		case syscall.SIGINT, syscall.SIGTERM:
 			logger.Error("Caught signal, terminating.", zap.String("Signal", sig.String()))
 			cancel()
+		case syscall.SIGHUP:
+			logger.Info("Caught signal, reloading.", zap.String("Signal", sig.String()))
+			reload()
 		}
 	}
 }
@@ -83,19 +215,199 @@ func configureLogs() *zap.Logger {
 	return logger
 }
 
+func configureChannelzProxyServer(logger *zap.Logger) *grpc.ChannelzProxyServer {
+	channelzProxyServer := grpc.NewChannelzProxyServer(logger)
+	channelzProxyServer.SetConnectionCacheLimits(connCacheSize, connCacheIdleTTL)
+	channelzProxyServer.SetFanOutConcurrency(fanOutConcurrency)
+	channelzProxyServer.SetDefaultLimits(grpc.TargetLimits{
+		Rate:          targetRate,
+		Burst:         targetBurst,
+		MaxConcurrent: targetMaxConcurrent,
+		MaxQueue:      targetMaxQueue,
+		QueueTimeout:  targetQueueTimeout,
+	})
+	channelzProxyServer.SetDefaultSecurity(grpc.SecurityConfig{
+		Insecure:   !upstreamTLS,
+		CAFile:     tlsCAFile,
+		CertFile:   tlsCertFile,
+		KeyFile:    tlsKeyFile,
+		ServerName: tlsServerName,
+	})
+	if allowCIDRs != "" || allowHostnames != "" || allowPorts != "" {
+		allowlist, err := grpc.NewAllowlist(util.SplitList(allowCIDRs), util.SplitList(allowHostnames),
+			util.SplitList(allowPorts), allowDiscovered)
+		util.FatalIf(err)
+		channelzProxyServer.SetAllowlist(allowlist)
+	} else {
+		logger.Warn("No destination allowlist configured, any address can be dialed")
+	}
+	if tlsTargetSecurityFile != "" {
+		targetSecurity, err := grpc.LoadTargetSecurityFile(tlsTargetSecurityFile)
+		util.FatalIf(err)
+		for address, security := range targetSecurity {
+			channelzProxyServer.SetTargetSecurity(address, security)
+		}
+	}
+	return channelzProxyServer
+}
+
+// configureAuthenticator returns the authenticator of the http api, nil if authentication is disabled
+func configureAuthenticator(ctx context.Context, logger *zap.Logger) auth.Authenticator {
+	var chain auth.Chain
+	if authTokensFile != "" {
+		tokenAuthenticator, err := auth.LoadTokensFile(authTokensFile)
+		util.FatalIf(err)
+		chain = append(chain, tokenAuthenticator)
+	}
+	if authJWKS != "" {
+		keySet := auth.NewKeySet(logger, authJWKS)
+		util.FatalIf(keySet.Refresh(ctx))
+		go keySet.Run(ctx, authJWKSRefresh)
+		chain = append(chain, auth.NewJWTAuthenticator(keySet, auth.JWTConfig{
+			Issuer:      authJWTIssuer,
+			Audience:    authJWTAudience,
+			GroupsClaim: authJWTGroupsClaim,
+		}))
+	}
+	if len(chain) == 0 {
+		logger.Warn("No authentication configured, the api is open to anyone reaching it")
+		return nil
+	}
+	return chain
+}
+
+func configureAuditLogger() *audit.Logger {
+	switch auditLog {
+	case "":
+		return nil
+	case "-":
+		return audit.NewLogger(os.Stdout)
+	}
+	file, err := audit.NewRotatingFile(auditLog, int64(auditLogMaxSize)*1024*1024, auditLogMaxBackups)
+	util.FatalIf(err)
+	return audit.NewLogger(file)
+}
+
 func start() {
 	if displayVersion {
 		doDisplayVersion()
 	}
 	logger := configureLogs()
 	ctx, cancel := context.WithCancel(context.Background())
-	go handleSignals(cancel, logger)
 	if testServerAddress != "" {
 		go grpc.StartTestServer(ctx, logger, testServerAddress)
 		go grpc.StartTestClients(ctx, logger, testServerAddress)
 	}
 
-	web.StartServer(ctx, listenAddress, logger)
+	channelzProxyServer := configureChannelzProxyServer(logger)
+	go channelzProxyServer.RunConnectionJanitor(ctx)
+
+	var registry *discovery.Registry
+	reload := func() {}
+	if targetsFile != "" {
+		registry = discovery.NewRegistry(logger, channelzProxyServer, targetsFile)
+		util.FatalIf(registry.Reload())
+		channelzProxyServer.AddHostResolver(registry.Resolve)
+		channelzProxyServer.AddKnownAddresses(registry.HasAddress)
+		reload = func() {
+			if err := registry.Reload(); err != nil {
+				logger.Error("Error reloading targets, keeping previous targets", zap.Error(err))
+			}
+		}
+		go registry.Watch(ctx, targetsReloadInterval)
+	}
+	go handleSignals(cancel, logger, reload)
+
+	// Configured targets are known, hosts are resolved on every check to follow registry and discovery changes
+	configuredHosts := append(util.SplitList(metricsTargets), util.SplitList(historyTargets)...)
+	channelzProxyServer.AddKnownAddresses(func(address string) bool {
+		for _, host := range configuredHosts {
+			if channelzProxyServer.ResolveHost(host) == address {
+				return true
+			}
+		}
+		return false
+	})
+
+	var historyStore *history.Store
+	if historyPath != "" {
+		var err error
+		historyStore, err = history.OpenStore(historyPath)
+		util.FatalIf(err)
+		defer historyStore.Close()
+		targets := util.SplitList(historyTargets)
+		collector := history.NewCollector(logger, channelzProxyServer, historyStore, func() []string {
+			if registry == nil {
+				return targets
+			}
+			return append(registry.Names(), targets...)
+		}, historyInterval, historyRetention)
+		if registry != nil {
+			collector.SetTargetInterval(func(host string) (time.Duration, bool) {
+				target, ok := registry.Get(host)
+				return target.PollInterval, ok && target.PollInterval > 0
+			})
+		}
+		go collector.Run(ctx)
+	}
+
+	var podDiscovery *discovery.PodDiscovery
+	if discoveryEnabled {
+		clientset, err := discovery.NewClientset(kubeconfig)
+		util.FatalIf(err)
+		podDiscovery = discovery.NewPodDiscovery(logger, clientset, discovery.PodDiscoveryConfig{
+			Namespace:      discoveryNamespace,
+			LabelSelector:  discoverySelector,
+			PortName:       discoveryPortName,
+			PortAnnotation: discoveryPortAnnotation,
+		})
+		channelzProxyServer.AddHostResolver(podDiscovery.Resolve)
+		channelzProxyServer.AddKnownAddresses(podDiscovery.HasAddress)
+		go podDiscovery.Run(ctx, discoveryInterval)
+	}
+
+	authenticator := configureAuthenticator(ctx, logger)
+	var policy *auth.Policy
+	if authPolicyFile != "" {
+		if authenticator == nil {
+			util.FatalIf(fmt.Errorf("--auth-policy-file requires --auth-tokens-file or --auth-jwks"))
+		}
+		var err error
+		policy, err = auth.LoadPolicyFile(authPolicyFile)
+		util.FatalIf(err)
+	}
+
+	corsConfig := web.CorsConfig{
+		AllowedOrigins:   util.SplitList(corsAllowedOrigins),
+		AllowedHeaders:   util.SplitList(corsAllowedHeaders),
+		AllowedMethods:   util.SplitList(corsAllowedMethods),
+		AllowCredentials: corsAllowCredentials,
+		MaxAge:           corsMaxAge,
+	}
+	util.FatalIf(corsConfig.Validate())
+
+	auditLogger := configureAuditLogger()
+	if auditLogger != nil {
+		defer auditLogger.Close()
+	}
+
+	serverConfig := web.ServerConfig{
+		ListenAddress:           listenAddress,
+		MetricsTargets:          util.SplitList(metricsTargets),
+		ScrapeTimeout:           metricsScrapeTimeout,
+		WatchInterval:           watchInterval,
+		History:                 historyStore,
+		Discovery:               podDiscovery,
+		Registry:                registry,
+		Authenticator:           authenticator,
+		Policy:                  policy,
+		Cors:                    corsConfig,
+		ClientLimits:            web.ClientLimits{Rate: clientRate, Burst: clientBurst},
+		Audit:                   web.AuditConfig{Logger: auditLogger, SkipRoutes: util.SplitList(auditSkipRoutes)},
+		CertificateExpiryWindow: certificateExpiryWindow,
+		TrustedProxies:          util.SplitList(trustedProxies),
+	}
+	web.StartServer(ctx, logger, channelzProxyServer, serverConfig)
 }
 
 func main() {
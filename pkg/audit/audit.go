@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is the audit record of an api call
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Principal is the authenticated subject, empty when authentication is disabled or failed
+	Principal  string            `json:"principal,omitempty"`
+	AuthMethod string            `json:"authMethod,omitempty"`
+	SourceIP   string            `json:"sourceIp"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Targets    []string          `json:"targets,omitempty"`
+	EntityIds  map[string]string `json:"entityIds,omitempty"`
+	Status     int               `json:"status"`
+	LatencyMs  float64           `json:"latencyMs"`
+	// UpstreamRpcs and UpstreamLatencyMs cover the channelz rpcs sent for the call
+	UpstreamRpcs      int     `json:"upstreamRpcs"`
+	UpstreamLatencyMs float64 `json:"upstreamLatencyMs"`
+}
+
+// Logger writes audit entries as JSON lines
+type Logger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Log writes an entry on its own line
+func (l *Logger) Log(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal audit entry")
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		return errors.Wrap(err, "failed to write audit entry")
+	}
+	return nil
+}
+
+// Close closes the underlying writer if it is closable
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if closer, ok := l.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerWritesJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf)
+	require.NoError(t, logger.Log(Entry{
+		Time:      time.Unix(0, 0).UTC(),
+		Principal: "alice",
+		SourceIP:  "10.0.0.1",
+		Method:    "GET",
+		Route:     "/api/channel",
+		Targets:   []string{"localhost:3333"},
+		EntityIds: map[string]string{"channelId": "2"},
+		Status:    200,
+	}))
+	require.NoError(t, logger.Log(Entry{SourceIP: "10.0.0.2", Route: "/api/channels", Status: 401}))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var entry Entry
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "alice", entry.Principal)
+	assert.Equal(t, []string{"localhost:3333"}, entry.Targets)
+	assert.Equal(t, "2", entry.EntityIds["channelId"])
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, 401, entry.Status)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := NewRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer file.Close()
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	readFile := func(path string) string {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+	assert.Equal(t, "dddddddd\n", readFile(path))
+	assert.Equal(t, "cccccccc\n", readFile(path+".1"))
+	assert.Equal(t, "bbbbbbbb\n", readFile(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFileKeepsExistingSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("aaaaaaaa\n"), 0600))
+	file, err := NewRotatingFile(path, 10, 1)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.Write([]byte("bbbbbbbb\n"))
+	require.NoError(t, err)
+	content, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "aaaaaaaa\n", string(content))
+}
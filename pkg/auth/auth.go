@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoCredentials is returned by authenticators when the request has no credentials they handle
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is an authenticated client
+type Principal struct {
+	Subject string   `json:"subject"`
+	Groups  []string `json:"groups,omitempty"`
+	// Method is the authentication method, token or jwt
+	Method string `json:"method"`
+}
+
+// Authenticator identifies the principal of a request.
+// It returns ErrNoCredentials if the request has no credentials it handles.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// BearerToken returns the bearer token of the authorization header, empty if there is none
+func BearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Chain tries authenticators in order until one handles the request credentials
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// staticToken is an entry of the tokens file
+type staticToken struct {
+	Token   string   `yaml:"token"`
+	Subject string   `yaml:"subject"`
+	Groups  []string `yaml:"groups"`
+}
+
+// TokenAuthenticator authenticates static bearer tokens
+type TokenAuthenticator struct {
+	// Tokens are indexed by their sha256 to not compare secrets byte by byte
+	principals map[[sha256.Size]byte]*Principal
+}
+
+// LoadTokensFile reads a yaml list of tokens with their subject and groups
+func LoadTokensFile(path string) (*TokenAuthenticator, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read tokens file")
+	}
+	var tokens []staticToken
+	if err := yaml.Unmarshal(content, &tokens); err != nil {
+		return nil, errors.Wrap(err, "failed to parse tokens file")
+	}
+	authenticator := &TokenAuthenticator{principals: make(map[[sha256.Size]byte]*Principal, len(tokens))}
+	for i, token := range tokens {
+		if token.Token == "" || token.Subject == "" {
+			return nil, errors.Errorf("token %d should have a token and a subject", i)
+		}
+		authenticator.principals[sha256.Sum256([]byte(token.Token))] = &Principal{
+			Subject: token.Subject,
+			Groups:  token.Groups,
+			Method:  "token",
+		}
+	}
+	return authenticator, nil
+}
+
+func (t *TokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	principal, ok := t.principals[sha256.Sum256([]byte(token))]
+	if !ok {
+		// Let a following authenticator try, the token may be a jwt
+		return nil, ErrNoCredentials
+	}
+	return principal, nil
+}
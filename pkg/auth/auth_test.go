@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithToken(token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/api/channels", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("- token: s3cret\n  subject: ci\n  groups: [readers]\n"), 0600))
+	authenticator, err := LoadTokensFile(path)
+	require.NoError(t, err)
+
+	principal, err := authenticator.Authenticate(requestWithToken("s3cret"))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Subject: "ci", Groups: []string{"readers"}, Method: "token"}, principal)
+
+	_, err = authenticator.Authenticate(requestWithToken("other"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	_, err = authenticator.Authenticate(requestWithToken(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	_, err = Chain{authenticator}.Authenticate(requestWithToken("other"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
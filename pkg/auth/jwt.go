@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// jwtMethods are the accepted signing algorithms, symmetric ones are excluded as keys come from a JWKS
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JWTConfig configures the verification of JWTs
+type JWTConfig struct {
+	// Issuer is the expected iss claim, not checked if empty
+	Issuer string
+	// Audience is the expected aud claim, not checked if empty
+	Audience string
+	// GroupsClaim is the claim holding the groups of the principal
+	GroupsClaim string
+}
+
+// JWTAuthenticator authenticates bearer JWTs signed by a key of a JWKS
+type JWTAuthenticator struct {
+	keySet *KeySet
+	config JWTConfig
+	parser *jwt.Parser
+}
+
+func NewJWTAuthenticator(keySet *KeySet, config JWTConfig) *JWTAuthenticator {
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return &JWTAuthenticator{
+		keySet: keySet,
+		config: config,
+		parser: jwt.NewParser(jwt.WithValidMethods(jwtMethods)),
+	}
+}
+
+func (j *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	raw := BearerToken(r)
+	// A jwt has three dot separated parts, other tokens are left to other authenticators
+	if raw == "" || strings.Count(raw, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+	claims := jwt.MapClaims{}
+	_, err := j.parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return j.keySet.Key(r.Context(), kid)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid jwt")
+	}
+	// The parser only checks exp when present, a token without it would never expire
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("jwt has no expiration")
+	}
+	if j.config.Issuer != "" && !claims.VerifyIssuer(j.config.Issuer, true) {
+		return nil, errors.New("invalid jwt issuer")
+	}
+	if j.config.Audience != "" && !claims.VerifyAudience(j.config.Audience, true) {
+		return nil, errors.New("invalid jwt audience")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("jwt has no subject")
+	}
+	return &Principal{
+		Subject: subject,
+		Groups:  stringList(claims[j.config.GroupsClaim]),
+		Method:  "jwt",
+	}, nil
+}
+
+// stringList converts a claim holding a string or a list of strings
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		res := make([]string, 0, len(value))
+		for _, element := range value {
+			if str, ok := element.(string); ok {
+				res = append(res, str)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
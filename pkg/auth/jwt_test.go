@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func testJWKS(t *testing.T, kid string, key *rsa.PrivateKey) []byte {
+	content, err := json.Marshal(jsonWebKeySet{Keys: []jsonWebKey{{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	require.NoError(t, err)
+	return content
+}
+
+func signToken(t *testing.T, kid string, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, testJWKS(t, "key-1", key), 0600))
+	keySet := NewKeySet(zap.NewNop(), path)
+	require.NoError(t, keySet.Refresh(context.Background()))
+	authenticator := NewJWTAuthenticator(keySet, JWTConfig{Issuer: "https://issuer", Audience: "channelz-proxy"})
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":    "https://issuer",
+			"aud":    []string{"channelz-proxy"},
+			"sub":    "alice",
+			"groups": []string{"sre"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	principal, err := authenticator.Authenticate(requestWithToken(signToken(t, "key-1", key, validClaims())))
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Subject: "alice", Groups: []string{"sre"}, Method: "jwt"}, principal)
+
+	for name, mutate := range map[string]func(claims jwt.MapClaims){
+		"issuer":    func(claims jwt.MapClaims) { claims["iss"] = "https://other" },
+		"audience":  func(claims jwt.MapClaims) { claims["aud"] = "other" },
+		"expired":   func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no exp":    func(claims jwt.MapClaims) { delete(claims, "exp") },
+		"no sub":    func(claims jwt.MapClaims) { delete(claims, "sub") },
+		"empty sub": func(claims jwt.MapClaims) { claims["sub"] = "" },
+	} {
+		claims := validClaims()
+		mutate(claims)
+		_, err := authenticator.Authenticate(requestWithToken(signToken(t, "key-1", key, claims)))
+		assert.Error(t, err, name)
+		assert.NotErrorIs(t, err, ErrNoCredentials, name)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = authenticator.Authenticate(requestWithToken(signToken(t, "key-1", otherKey, validClaims())))
+	assert.Error(t, err, "wrong signature")
+
+	_, err = authenticator.Authenticate(requestWithToken("static-token"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestKeySetURL(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testJWKS(t, "key-1", key))
+	}))
+	defer server.Close()
+
+	keySet := NewKeySet(zap.NewNop(), server.URL)
+	// The first lookup of an unknown key id refreshes the keys
+	publicKey, err := keySet.Key(context.Background(), "key-1")
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(publicKey))
+	_, err = keySet.Key(context.Background(), "key-2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Resource is a channelz target a principal wants to access
+type Resource struct {
+	// Name is the registry name or the namespace/name of a discovered pod, empty for raw addresses
+	Name    string
+	Labels  map[string]string
+	Address string
+}
+
+// Rule grants or denies principals access to targets
+type Rule struct {
+	Name string `yaml:"name"`
+	// Effect is allow or deny, allow if empty
+	Effect string `yaml:"effect"`
+	// Subjects and Groups select the principals, * matches every principal
+	Subjects []string `yaml:"subjects"`
+	Groups   []string `yaml:"groups"`
+	// Targets are globs on target names, Selector a label selector and Addresses globs on addresses
+	Targets   []string `yaml:"targets"`
+	Selector  string   `yaml:"selector"`
+	Addresses []string `yaml:"addresses"`
+
+	selector labels.Selector
+}
+
+// Decision is the result of an authorization with the rule that applied
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// Policy is an ordered list of rules, the first rule matching both the principal and the target applies.
+// Access is denied when no rule matches.
+type Policy struct {
+	rules []Rule
+}
+
+type policyFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadPolicyFile reads a yaml file of rules
+func LoadPolicyFile(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read policy file")
+	}
+	var file policyFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to parse policy file")
+	}
+	return NewPolicy(file.Rules)
+}
+
+// NewPolicy validates the rules and returns the policy
+func NewPolicy(rules []Rule) (*Policy, error) {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if rule.Effect == "" {
+			rule.Effect = "allow"
+		}
+		if rule.Effect != "allow" && rule.Effect != "deny" {
+			return nil, errors.Errorf("rule %q: effect should be allow or deny", rule.Name)
+		}
+		if len(rule.Subjects) == 0 && len(rule.Groups) == 0 {
+			return nil, errors.Errorf("rule %q should have subjects or groups", rule.Name)
+		}
+		if len(rule.Targets) == 0 && rule.Selector == "" && len(rule.Addresses) == 0 {
+			return nil, errors.Errorf("rule %q should have targets, a selector or addresses", rule.Name)
+		}
+		for _, glob := range append(append([]string{}, rule.Targets...), rule.Addresses...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, errors.Wrapf(err, "rule %q: invalid glob %q", rule.Name, glob)
+			}
+		}
+		if rule.Selector != "" {
+			selector, err := labels.Parse(rule.Selector)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %q: invalid selector", rule.Name)
+			}
+			rule.selector = selector
+		}
+	}
+	return &Policy{rules: rules}, nil
+}
+
+func (r *Rule) matchesPrincipal(principal *Principal) bool {
+	if principal == nil {
+		return false
+	}
+	for _, subject := range r.Subjects {
+		if subject == "*" || subject == principal.Subject {
+			return true
+		}
+	}
+	for _, group := range r.Groups {
+		for _, principalGroup := range principal.Groups {
+			if group == "*" || group == principalGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(globs []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesResource(resource Resource) bool {
+	if matchAny(r.Targets, resource.Name) || matchAny(r.Addresses, resource.Address) {
+		return true
+	}
+	return r.selector != nil && resource.Labels != nil && r.selector.Matches(labels.Set(resource.Labels))
+}
+
+func describeResource(resource Resource) string {
+	if resource.Name != "" {
+		return fmt.Sprintf("target %q", resource.Name)
+	}
+	return fmt.Sprintf("address %q", resource.Address)
+}
+
+// Authorize returns the decision of the first rule matching the principal and the resource
+func (p *Policy) Authorize(principal *Principal, resource Resource) Decision {
+	for _, rule := range p.rules {
+		if !rule.matchesPrincipal(principal) || !rule.matchesResource(resource) {
+			continue
+		}
+		if rule.Effect == "deny" {
+			return Decision{Rule: rule.Name, Reason: fmt.Sprintf("access to %s denied by rule %q", describeResource(resource), rule.Name)}
+		}
+		return Decision{Allowed: true, Rule: rule.Name, Reason: fmt.Sprintf("access to %s allowed by rule %q", describeResource(resource), rule.Name)}
+	}
+	if principal == nil {
+		return Decision{Reason: "unauthenticated requests are denied by the policy"}
+	}
+	groups := ""
+	if len(principal.Groups) > 0 {
+		groups = fmt.Sprintf(" (groups %s)", strings.Join(principal.Groups, ", "))
+	}
+	return Decision{Reason: fmt.Sprintf("no rule allows subject %q%s to access %s", principal.Subject, groups, describeResource(resource))}
+}
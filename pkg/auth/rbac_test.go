@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy, err := NewPolicy([]Rule{
+		{Name: "no-prod-db", Effect: "deny", Groups: []string{"payments"}, Selector: "service=db,env=prod"},
+		{Name: "payments", Groups: []string{"payments"}, Selector: "team=payments"},
+		{Name: "payments-pods", Groups: []string{"payments"}, Targets: []string{"payments/*"}},
+		{Name: "sre", Groups: []string{"sre"}, Addresses: []string{"*"}, Targets: []string{"*"}},
+	})
+	require.NoError(t, err)
+	alice := &Principal{Subject: "alice", Groups: []string{"payments"}}
+	bob := &Principal{Subject: "bob", Groups: []string{"sre"}}
+
+	api := Resource{Name: "payments-api", Labels: map[string]string{"team": "payments", "env": "prod"}, Address: "10.0.0.1:9000"}
+	db := Resource{Name: "payments-db", Labels: map[string]string{"team": "payments", "service": "db", "env": "prod"}, Address: "10.0.0.2:9000"}
+	pod := Resource{Name: "payments/api-0", Address: "10.0.0.3:9000"}
+	raw := Resource{Address: "10.0.0.4:9000"}
+
+	assert.Equal(t, Decision{Allowed: true, Rule: "payments", Reason: `access to target "payments-api" allowed by rule "payments"`}, policy.Authorize(alice, api))
+	assert.Equal(t, Decision{Rule: "no-prod-db", Reason: `access to target "payments-db" denied by rule "no-prod-db"`}, policy.Authorize(alice, db))
+	assert.True(t, policy.Authorize(alice, pod).Allowed)
+	assert.Equal(t, Decision{Reason: `no rule allows subject "alice" (groups payments) to access address "10.0.0.4:9000"`}, policy.Authorize(alice, raw))
+
+	assert.True(t, policy.Authorize(bob, db).Allowed)
+	assert.True(t, policy.Authorize(bob, raw).Allowed)
+	assert.False(t, policy.Authorize(nil, raw).Allowed)
+}
+
+func TestNewPolicyValidation(t *testing.T) {
+	_, err := NewPolicy([]Rule{{Name: "no-principal", Targets: []string{"*"}}})
+	assert.Error(t, err)
+	_, err = NewPolicy([]Rule{{Name: "no-target", Subjects: []string{"*"}}})
+	assert.Error(t, err)
+	_, err = NewPolicy([]Rule{{Name: "effect", Effect: "maybe", Subjects: []string{"*"}, Targets: []string{"*"}}})
+	assert.Error(t, err)
+	_, err = NewPolicy([]Rule{{Name: "selector", Subjects: []string{"*"}, Selector: "env in prod"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,220 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	DefaultPortName       = "channelz"
+	DefaultPortAnnotation = "channelz-proxy/port"
+)
+
+// PodTarget is a channelz endpoint exposed by a pod
+type PodTarget struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Node      string            `json:"node"`
+	Address   string            `json:"address"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// PodDiscoveryConfig selects the pods exposing channelz
+type PodDiscoveryConfig struct {
+	// Namespace of the pods, all namespaces if empty
+	Namespace string
+	// LabelSelector of the pods, all pods if empty
+	LabelSelector string
+	// PortName is the name of the container port serving channelz
+	PortName string
+	// PortAnnotation is the pod annotation holding the channelz port, it takes precedence over PortName
+	PortAnnotation string
+}
+
+// PodDiscovery periodically lists pods through the kubernetes API
+type PodDiscovery struct {
+	logger    *zap.Logger
+	clientset kubernetes.Interface
+	config    PodDiscoveryConfig
+
+	mu      sync.RWMutex
+	targets []PodTarget
+}
+
+// NewClientset creates a kubernetes client from a kubeconfig file, or from the in-cluster configuration if empty
+func NewClientset(kubeconfig string) (kubernetes.Interface, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading kubernetes configuration")
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+func NewPodDiscovery(logger *zap.Logger, clientset kubernetes.Interface, config PodDiscoveryConfig) *PodDiscovery {
+	if config.PortName == "" {
+		config.PortName = DefaultPortName
+	}
+	if config.PortAnnotation == "" {
+		config.PortAnnotation = DefaultPortAnnotation
+	}
+	return &PodDiscovery{
+		logger:    logger.Named("PodDiscovery"),
+		clientset: clientset,
+		config:    config,
+	}
+}
+
+// channelzPort returns the channelz port of a pod from its annotation or its named container port
+func (d *PodDiscovery) channelzPort(pod *corev1.Pod) (int, error) {
+	if value, ok := pod.Annotations[d.config.PortAnnotation]; ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("annotation %s should be a port number, got %q", d.config.PortAnnotation, value)
+		}
+		return port, nil
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == d.config.PortName {
+				return int(port.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no %s annotation or container port named %s", d.config.PortAnnotation, d.config.PortName)
+}
+
+// Refresh lists the pods and replaces the discovered targets
+func (d *PodDiscovery) Refresh(ctx context.Context) error {
+	pods, err := d.clientset.CoreV1().Pods(d.config.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: d.config.LabelSelector,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error listing pods")
+	}
+	targets := make([]PodTarget, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		port, err := d.channelzPort(pod)
+		if err != nil {
+			d.logger.Debug("Ignoring pod", zap.String("namespace", pod.Namespace),
+				zap.String("pod", pod.Name), zap.Error(err))
+			continue
+		}
+		targets = append(targets, PodTarget{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Node:      pod.Spec.NodeName,
+			Address:   net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)),
+			Labels:    pod.Labels,
+		})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Namespace != targets[j].Namespace {
+			return targets[i].Namespace < targets[j].Namespace
+		}
+		return targets[i].Name < targets[j].Name
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.targets = targets
+	return nil
+}
+
+// Targets returns the discovered pods
+func (d *PodDiscovery) Targets() []PodTarget {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.targets
+}
+
+// Lookup returns the pod addressed by namespace/name, or by name when it is unique across namespaces.
+// A name matching pods of several namespaces is an error.
+func (d *PodDiscovery) Lookup(host string) (PodTarget, bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var matches []PodTarget
+	for _, target := range d.targets {
+		if host == target.Namespace+"/"+target.Name {
+			return target, true, nil
+		}
+		if host == target.Name {
+			matches = append(matches, target)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return PodTarget{}, false, nil
+	case 1:
+		return matches[0], true, nil
+	}
+	candidates := make([]string, 0, len(matches))
+	for _, target := range matches {
+		candidates = append(candidates, target.Namespace+"/"+target.Name)
+	}
+	return PodTarget{}, false, errors.Errorf("pod name %q is ambiguous, use one of %s", host, strings.Join(candidates, ", "))
+}
+
+// HasAddress returns true if a discovered pod has the given channelz address
+func (d *PodDiscovery) HasAddress(address string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, target := range d.targets {
+		if target.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupAddress returns the pods whose channelz address has the given canonical form, see grpc.CanonicalAddress
+func (d *PodDiscovery) LookupAddress(address string) []PodTarget {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var res []PodTarget
+	for _, target := range d.targets {
+		if canonical, err := grpc.CanonicalAddress(target.Address); err == nil && canonical == address {
+			res = append(res, target)
+		}
+	}
+	return res
+}
+
+// Resolve returns the channelz address of a pod addressed by name or namespace/name
+func (d *PodDiscovery) Resolve(host string) (string, bool) {
+	target, ok, _ := d.Lookup(host)
+	return target.Address, ok
+}
+
+// Run refreshes the discovered pods every interval until the context is done
+func (d *PodDiscovery) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Refresh(ctx); err != nil {
+			d.logger.Warn("Error discovering pods", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
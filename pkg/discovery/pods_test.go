@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(name string, labels map[string]string, annotations map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: []corev1.Container{{Name: "app", Ports: ports}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+	}
+}
+
+func TestPodDiscoveryRefresh(t *testing.T) {
+	appLabels := map[string]string{"app": "api"}
+	namedPort := testPod("named-port", appLabels, nil,
+		corev1.ContainerPort{Name: "http", ContainerPort: 8080},
+		corev1.ContainerPort{Name: "channelz", ContainerPort: 9000})
+	annotated := testPod("annotated", appLabels, map[string]string{DefaultPortAnnotation: "9001"})
+	noPort := testPod("no-port", appLabels, nil)
+	otherApp := testPod("other-app", map[string]string{"app": "other"}, nil,
+		corev1.ContainerPort{Name: "channelz", ContainerPort: 9000})
+	pending := testPod("pending", appLabels, nil, corev1.ContainerPort{Name: "channelz", ContainerPort: 9000})
+	pending.Status = corev1.PodStatus{Phase: corev1.PodPending}
+	clientset := fake.NewSimpleClientset(namedPort, annotated, noPort, otherApp, pending)
+
+	d := NewPodDiscovery(zap.NewNop(), clientset, PodDiscoveryConfig{Namespace: "default", LabelSelector: "app=api"})
+	err := d.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []PodTarget{
+		{Name: "annotated", Namespace: "default", Node: "node-1", Address: "10.0.0.1:9001", Labels: appLabels},
+		{Name: "named-port", Namespace: "default", Node: "node-1", Address: "10.0.0.1:9000", Labels: appLabels},
+	}, d.Targets())
+}
+
+func TestPodDiscoveryResolve(t *testing.T) {
+	pod := testPod("api-0", nil, map[string]string{DefaultPortAnnotation: "9000"})
+	d := NewPodDiscovery(zap.NewNop(), fake.NewSimpleClientset(pod), PodDiscoveryConfig{})
+	assert.NoError(t, d.Refresh(context.Background()))
+
+	address, ok := d.Resolve("api-0")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1:9000", address)
+	address, ok = d.Resolve("default/api-0")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1:9000", address)
+	_, ok = d.Resolve("api-1")
+	assert.False(t, ok)
+}
+
+func TestPodDiscoveryLookupAmbiguous(t *testing.T) {
+	pod := testPod("api-0", nil, map[string]string{DefaultPortAnnotation: "9000"})
+	other := testPod("api-0", nil, map[string]string{DefaultPortAnnotation: "9000"})
+	other.Namespace = "other"
+	other.Status.PodIP = "10.0.0.2"
+	d := NewPodDiscovery(zap.NewNop(), fake.NewSimpleClientset(pod, other), PodDiscoveryConfig{})
+	assert.NoError(t, d.Refresh(context.Background()))
+
+	_, ok, err := d.Lookup("api-0")
+	assert.False(t, ok)
+	assert.EqualError(t, err, `pod name "api-0" is ambiguous, use one of default/api-0, other/api-0`)
+	_, ok = d.Resolve("api-0")
+	assert.False(t, ok)
+
+	target, ok, err := d.Lookup("other/api-0")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.2:9000", target.Address)
+}
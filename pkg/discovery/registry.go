@@ -0,0 +1,287 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Target is a named channelz endpoint declared in the registry file
+type Target struct {
+	Name    string            `yaml:"name"`
+	Address string            `yaml:"address"`
+	Labels  map[string]string `yaml:"labels"`
+	// TLS overrides the default upstream security of the target
+	TLS *grpc.SecurityConfig `yaml:"tls"`
+	// Credentials are sent with every call to the target
+	Credentials *grpc.CallCredentials `yaml:"credentials"`
+	// Timeout of upstream calls to the target, the route timeout is used if 0
+	Timeout time.Duration `yaml:"timeout"`
+	// PollInterval is used by watches and history snapshots of the target
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// Limits override the default rate limit and concurrency of upstream rpcs to the target
+	Limits *grpc.TargetLimits `yaml:"limits"`
+}
+
+// MarshalJSON renders durations as strings and hides credentials
+func (t Target) MarshalJSON() ([]byte, error) {
+	res := struct {
+		Name         string             `json:"name"`
+		Address      string             `json:"address"`
+		Labels       map[string]string  `json:"labels,omitempty"`
+		TLS          bool               `json:"tls"`
+		Credentials  bool               `json:"credentials"`
+		Timeout      string             `json:"timeout,omitempty"`
+		PollInterval string             `json:"pollInterval,omitempty"`
+		Limits       *grpc.TargetLimits `json:"limits,omitempty"`
+	}{
+		Name:        t.Name,
+		Address:     t.Address,
+		Labels:      t.Labels,
+		TLS:         t.TLS != nil && !t.TLS.Insecure,
+		Credentials: t.Credentials != nil,
+		Limits:      t.Limits,
+	}
+	if t.Timeout > 0 {
+		res.Timeout = t.Timeout.String()
+	}
+	if t.PollInterval > 0 {
+		res.PollInterval = t.PollInterval.String()
+	}
+	return json.Marshal(res)
+}
+
+// security returns the upstream security of the target, nil if it uses the default one
+func (t Target) security(defaultSecurity grpc.SecurityConfig) *grpc.SecurityConfig {
+	if t.TLS == nil && t.Credentials == nil {
+		return nil
+	}
+	security := defaultSecurity
+	if t.TLS != nil {
+		security = *t.TLS
+	}
+	if t.Credentials != nil {
+		security.Credentials = t.Credentials
+	}
+	return &security
+}
+
+type registryFile struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// LoadTargetsFile reads and validates a yaml file of named targets.
+// Target security is validated on top of defaultSecurity, like it is applied.
+func LoadTargetsFile(path string, defaultSecurity grpc.SecurityConfig) ([]Target, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read targets file")
+	}
+	var file registryFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to parse targets file")
+	}
+	seen := make(map[string]bool)
+	for _, target := range file.Targets {
+		if target.Name == "" || target.Address == "" {
+			return nil, errors.Errorf("target %q should have a name and an address", target.Name)
+		}
+		if seen[target.Name] {
+			return nil, errors.Errorf("duplicate target %q", target.Name)
+		}
+		seen[target.Name] = true
+		if security := target.security(defaultSecurity); security != nil {
+			if err := security.Validate(); err != nil {
+				return nil, errors.Wrapf(err, "invalid security for target %q", target.Name)
+			}
+		}
+	}
+	sort.Slice(file.Targets, func(i, j int) bool { return file.Targets[i].Name < file.Targets[j].Name })
+	return file.Targets, nil
+}
+
+// Registry holds the named targets of a file and keeps them in sync with it
+type Registry struct {
+	logger *zap.Logger
+	c      *grpc.ChannelzProxyServer
+	path   string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	targets map[string]Target
+	// shadowedSecurity and shadowedLimits hold the overrides of target addresses set before the registry,
+	// like the --tls-target-file ones, nil when there was none. They are restored on reload.
+	shadowedSecurity map[string]*grpc.SecurityConfig
+	shadowedLimits   map[string]*grpc.TargetLimits
+}
+
+func NewRegistry(logger *zap.Logger, c *grpc.ChannelzProxyServer, path string) *Registry {
+	return &Registry{
+		logger:  logger.Named("Registry"),
+		c:       c,
+		path:    path,
+		targets: make(map[string]Target),
+
+		shadowedSecurity: make(map[string]*grpc.SecurityConfig),
+		shadowedLimits:   make(map[string]*grpc.TargetLimits),
+	}
+}
+
+// Reload reads the targets file and replaces the registry.
+// On error, the previous targets are kept.
+func (r *Registry) Reload() error {
+	stat, err := os.Stat(r.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat targets file")
+	}
+	targets, err := LoadTargetsFile(r.path, r.c.DefaultSecurity())
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.restoreShadowed()
+	r.targets = make(map[string]Target, len(targets))
+	defaultSecurity := r.c.DefaultSecurity()
+	for _, target := range targets {
+		r.targets[target.Name] = target
+		if security := target.security(defaultSecurity); security != nil {
+			if _, ok := r.shadowedSecurity[target.Address]; !ok {
+				r.shadowedSecurity[target.Address] = nil
+				if previous, ok := r.c.TargetSecurity(target.Address); ok {
+					r.shadowedSecurity[target.Address] = &previous
+				}
+			}
+			r.c.SetTargetSecurity(target.Address, *security)
+		}
+		if target.Limits != nil {
+			if _, ok := r.shadowedLimits[target.Address]; !ok {
+				r.shadowedLimits[target.Address] = nil
+				if previous, ok := r.c.TargetLimits(target.Address); ok {
+					r.shadowedLimits[target.Address] = &previous
+				}
+			}
+			r.c.SetTargetLimits(target.Address, *target.Limits)
+		}
+	}
+	r.modTime = stat.ModTime()
+	r.logger.Info("Loaded targets", zap.String("path", r.path), zap.Int("targets", len(targets)))
+	return nil
+}
+
+// restoreShadowed puts back the overrides replaced by the targets of the registry
+func (r *Registry) restoreShadowed() {
+	for address, security := range r.shadowedSecurity {
+		if security != nil {
+			r.c.SetTargetSecurity(address, *security)
+		} else {
+			r.c.RemoveTargetSecurity(address)
+		}
+	}
+	for address, limits := range r.shadowedLimits {
+		if limits != nil {
+			r.c.SetTargetLimits(address, *limits)
+		} else {
+			r.c.RemoveTargetLimits(address)
+		}
+	}
+	r.shadowedSecurity = make(map[string]*grpc.SecurityConfig)
+	r.shadowedLimits = make(map[string]*grpc.TargetLimits)
+}
+
+func (r *Registry) fileChanged() bool {
+	stat, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !stat.ModTime().Equal(r.modTime)
+}
+
+// Watch reloads the registry when the file modification time changes until the context is done
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.fileChanged() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Error("Error reloading targets, keeping previous targets", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Get returns the target with the given name
+func (r *Registry) Get(name string) (Target, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	target, ok := r.targets[name]
+	return target, ok
+}
+
+// Targets returns the registered targets sorted by name
+func (r *Registry) Targets() []Target {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := make([]Target, 0, len(r.targets))
+	for _, target := range r.targets {
+		res = append(res, target)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Names returns the names of the registered targets
+func (r *Registry) Names() []string {
+	targets := r.Targets()
+	res := make([]string, 0, len(targets))
+	for _, target := range targets {
+		res = append(res, target.Name)
+	}
+	return res
+}
+
+// HasAddress returns true if a target has the given address
+func (r *Registry) HasAddress(address string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, target := range r.targets {
+		if target.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupAddress returns the targets whose address has the given canonical form, see grpc.CanonicalAddress
+func (r *Registry) LookupAddress(address string) []Target {
+	var res []Target
+	for _, target := range r.Targets() {
+		if canonical, err := grpc.CanonicalAddress(target.Address); err == nil && canonical == address {
+			res = append(res, target)
+		}
+	}
+	return res
+}
+
+// Resolve returns the address of a target addressed by name
+func (r *Registry) Resolve(host string) (string, bool) {
+	target, ok := r.Get(host)
+	return target.Address, ok
+}
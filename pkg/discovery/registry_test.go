@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testTargetsFile = `
+targets:
+  - name: api-prod
+    address: 10.0.0.1:9000
+    labels:
+      cluster: eu-1
+      service: api
+      env: prod
+    tls:
+      caFile: /etc/ca.crt
+    credentials:
+      bearerTokenFile: /var/run/token
+    timeout: 3s
+    pollInterval: 30s
+    limits:
+      rate: 20
+      burst: 40
+      maxConcurrent: 4
+      maxQueue: 10
+      queueTimeout: 2s
+  - name: api-dev
+    address: 10.0.1.1:9000
+`
+
+func writeTargetsFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func TestLoadTargetsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	writeTargetsFile(t, path, testTargetsFile)
+	targets, err := LoadTargetsFile(path, grpc.SecurityConfig{})
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "api-dev", targets[0].Name)
+	assert.Equal(t, "api-prod", targets[1].Name)
+	assert.Equal(t, 3*time.Second, targets[1].Timeout)
+	assert.Equal(t, 30*time.Second, targets[1].PollInterval)
+	assert.Equal(t, "prod", targets[1].Labels["env"])
+	assert.Equal(t, &grpc.TargetLimits{Rate: 20, Burst: 40, MaxConcurrent: 4, MaxQueue: 10, QueueTimeout: 2 * time.Second}, targets[1].Limits)
+
+	writeTargetsFile(t, path, "targets:\n  - name: a\n    address: x:1\n  - name: a\n    address: y:1\n")
+	_, err = LoadTargetsFile(path, grpc.SecurityConfig{})
+	assert.ErrorContains(t, err, "duplicate")
+
+	writeTargetsFile(t, path, "targets:\n  - name: a\n    address: x:1\n    tls:\n      insecure: true\n    credentials:\n      bearerToken: t\n")
+	_, err = LoadTargetsFile(path, grpc.SecurityConfig{})
+	assert.ErrorContains(t, err, "credentials require TLS")
+
+	// Credentials without tls use the default security, plaintext unless --tls is set
+	writeTargetsFile(t, path, "targets:\n  - name: a\n    address: x:1\n    credentials:\n      bearerToken: t\n")
+	_, err = LoadTargetsFile(path, grpc.InsecureSecurityConfig)
+	assert.ErrorContains(t, err, "credentials require TLS")
+	_, err = LoadTargetsFile(path, grpc.SecurityConfig{})
+	assert.NoError(t, err)
+}
+
+func TestRegistryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	writeTargetsFile(t, path, testTargetsFile)
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	r := NewRegistry(zap.NewNop(), c, path)
+	require.NoError(t, r.Reload())
+	assert.Equal(t, []string{"api-dev", "api-prod"}, r.Names())
+	address, ok := r.Resolve("api-prod")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1:9000", address)
+
+	// An invalid file keeps the previous targets
+	writeTargetsFile(t, path, "targets: [")
+	assert.Error(t, r.Reload())
+	assert.Equal(t, []string{"api-dev", "api-prod"}, r.Names())
+
+	writeTargetsFile(t, path, "targets:\n  - name: api-dev\n    address: 10.0.1.2:9000\n")
+	require.NoError(t, r.Reload())
+	assert.Equal(t, []string{"api-dev"}, r.Names())
+	_, ok = r.Resolve("api-prod")
+	assert.False(t, ok)
+}
+
+func TestRegistryReloadKeepsOtherOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	writeTargetsFile(t, path, testTargetsFile)
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	// Overrides of --tls-target-file on a registry address and on another address
+	fileSecurity := grpc.SecurityConfig{CAFile: "/etc/file-ca.crt", ServerName: "api.internal"}
+	c.SetTargetSecurity("10.0.0.1:9000", fileSecurity)
+	c.SetTargetSecurity("10.0.2.1:9000", fileSecurity)
+	fileLimits := grpc.TargetLimits{Rate: 5, Burst: 5}
+	c.SetTargetLimits("10.0.0.1:9000", fileLimits)
+
+	r := NewRegistry(zap.NewNop(), c, path)
+	require.NoError(t, r.Reload())
+	security, ok := c.TargetSecurity("10.0.0.1:9000")
+	require.True(t, ok)
+	assert.Equal(t, "/etc/ca.crt", security.CAFile)
+	limits, ok := c.TargetLimits("10.0.0.1:9000")
+	require.True(t, ok)
+	assert.Equal(t, float64(20), limits.Rate)
+
+	// Reloading the same targets keeps the registry overrides
+	require.NoError(t, r.Reload())
+	security, _ = c.TargetSecurity("10.0.0.1:9000")
+	assert.Equal(t, "/etc/ca.crt", security.CAFile)
+
+	// Removing the target restores the file overrides instead of the defaults
+	writeTargetsFile(t, path, "targets:\n  - name: api-dev\n    address: 10.0.1.2:9000\n")
+	require.NoError(t, r.Reload())
+	security, ok = c.TargetSecurity("10.0.0.1:9000")
+	require.True(t, ok)
+	assert.Equal(t, fileSecurity, security)
+	limits, ok = c.TargetLimits("10.0.0.1:9000")
+	require.True(t, ok)
+	assert.Equal(t, fileLimits, limits)
+	security, ok = c.TargetSecurity("10.0.2.1:9000")
+	require.True(t, ok)
+	assert.Equal(t, fileSecurity, security)
+
+	// Overrides the registry added alone are removed with their target
+	writeTargetsFile(t, path, "targets:\n  - name: api-dev\n    address: 10.0.1.2:9000\n    limits:\n      rate: 1\n")
+	require.NoError(t, r.Reload())
+	_, ok = c.TargetLimits("10.0.1.2:9000")
+	assert.True(t, ok)
+	writeTargetsFile(t, path, "targets:\n  - name: api-dev\n    address: 10.0.1.2:9000\n")
+	require.NoError(t, r.Reload())
+	_, ok = c.TargetLimits("10.0.1.2:9000")
+	assert.False(t, ok)
+}
+
+func TestTargetJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	writeTargetsFile(t, path, testTargetsFile)
+	targets, err := LoadTargetsFile(path, grpc.SecurityConfig{})
+	require.NoError(t, err)
+	content, err := json.Marshal(targets[1])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"api-prod","address":"10.0.0.1:9000","labels":{"cluster":"eu-1","service":"api","env":"prod"},
+		"tls":true,"credentials":true,"timeout":"3s","pollInterval":"30s",
+		"limits":{"rate":20,"burst":40,"maxConcurrent":4,"maxQueue":10,"queueTimeout":"2s"}}`, string(content))
+}
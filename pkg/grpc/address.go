@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// resolverSchemes are the grpc target schemes dialing a single host:port
+var resolverSchemes = []string{"dns:///", "passthrough:///", "ipv4:", "ipv6:"}
+
+// FormatAddress returns a readable representation of a channelz address:
+// ip:port for tcp addresses, unix:path for unix domain sockets
+func FormatAddress(address *channelzgrpc.Address) string {
+	if tcpIp := address.GetTcpipAddress(); tcpIp != nil {
+		ip := net.IP(tcpIp.GetIpAddress())
+		if len(ip) == 0 {
+			return ":" + strconv.Itoa(int(tcpIp.GetPort()))
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(int(tcpIp.GetPort())))
+	}
+	if uds := address.GetUdsAddress(); uds != nil {
+		return "unix:" + uds.GetFilename()
+	}
+	if other := address.GetOtherAddress(); other != nil {
+		return other.GetName()
+	}
+	return ""
+}
+
+// CanonicalAddress returns a single spelling of the endpoint dialed for a host:port address, so that
+// addresses dialing the same endpoint compare equal. The dns, passthrough, ipv4 and ipv6 resolver schemes
+// and a dns authority are stripped, the host is lower cased, IPv4-mapped IPv6 addresses are unmapped and
+// leading zeros of the port are dropped. Other schemes and addresses without a valid port are an error.
+func CanonicalAddress(address string) (string, error) {
+	canonical := strings.ToLower(strings.TrimSpace(address))
+	if rest, ok := cutPrefix(canonical, "dns://"); ok && !strings.HasPrefix(rest, "/") {
+		// dns://authority/host:port
+		_, canonical, _ = strings.Cut(rest, "/")
+	}
+	for _, scheme := range resolverSchemes {
+		if rest, ok := cutPrefix(canonical, scheme); ok {
+			canonical = rest
+			break
+		}
+	}
+	host, portStr, err := net.SplitHostPort(canonical)
+	if err != nil {
+		return "", errors.Errorf("invalid address %q, expected host:port", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 || host == "" {
+		return "", errors.Errorf("invalid address %q, expected host:port", address)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		host = ip.Unmap().String()
+	} else if strings.ContainsAny(host, ":/") {
+		// Another scheme, like xds:///host:port
+		return "", errors.Errorf("unsupported address %q", address)
+	} else {
+		host = strings.TrimSuffix(host, ".")
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+func cutPrefix(s string, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,51 @@
+package grpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+func TestFormatAddress(t *testing.T) {
+	ipv4 := &channelzgrpc.Address{Address: &channelzgrpc.Address_TcpipAddress{TcpipAddress: &channelzgrpc.Address_TcpIpAddress{
+		IpAddress: net.ParseIP("10.0.0.1").To4(), Port: 8080,
+	}}}
+	assert.Equal(t, "10.0.0.1:8080", FormatAddress(ipv4))
+
+	ipv6 := &channelzgrpc.Address{Address: &channelzgrpc.Address_TcpipAddress{TcpipAddress: &channelzgrpc.Address_TcpIpAddress{
+		IpAddress: net.ParseIP("::1"), Port: 443,
+	}}}
+	assert.Equal(t, "[::1]:443", FormatAddress(ipv6))
+
+	uds := &channelzgrpc.Address{Address: &channelzgrpc.Address_UdsAddress_{UdsAddress: &channelzgrpc.Address_UdsAddress{
+		Filename: "/tmp/grpc.sock",
+	}}}
+	assert.Equal(t, "unix:/tmp/grpc.sock", FormatAddress(uds))
+	assert.Equal(t, "", FormatAddress(nil))
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		"10.0.0.1:9000":                  "10.0.0.1:9000",
+		"10.0.0.1:09000":                 "10.0.0.1:9000",
+		"dns:///10.0.0.1:9000":           "10.0.0.1:9000",
+		"dns://8.8.8.8:53/10.0.0.1:9000": "10.0.0.1:9000",
+		"passthrough:///10.0.0.1:9000":   "10.0.0.1:9000",
+		"ipv4:10.0.0.1:9000":             "10.0.0.1:9000",
+		"[::ffff:10.0.0.1]:9000":         "10.0.0.1:9000",
+		"ipv6:[::ffff:10.0.0.1]:9000":    "10.0.0.1:9000",
+		"[0:0:0:0:0:0:0:1]:9000":         "[::1]:9000",
+		"API.svc.Cluster.local.:9000":    "api.svc.cluster.local:9000",
+	} {
+		canonical, err := CanonicalAddress(address)
+		assert.NoError(t, err, address)
+		assert.Equal(t, expected, canonical, address)
+	}
+	for _, address := range []string{"10.0.0.1", "10.0.0.1:http", "10.0.0.1:70000", "xds:///api:9000",
+		"unix:///tmp/grpc.sock", "ipv4:10.0.0.1:9000,10.0.0.2:9000", ":9000"} {
+		_, err := CanonicalAddress(address)
+		assert.Error(t, err, address)
+	}
+}
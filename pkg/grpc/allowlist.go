@@ -0,0 +1,131 @@
+package grpc
+
+import (
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange parses a single port like 9000 or a range like 9000-9100
+func ParsePortRange(value string) (PortRange, error) {
+	minStr, maxStr, isRange := strings.Cut(value, "-")
+	if !isRange {
+		maxStr = minStr
+	}
+	min, err := strconv.Atoi(minStr)
+	if err != nil {
+		return PortRange{}, errors.Errorf("invalid port %q", minStr)
+	}
+	max, err := strconv.Atoi(maxStr)
+	if err != nil {
+		return PortRange{}, errors.Errorf("invalid port %q", maxStr)
+	}
+	if min < 0 || max > 65535 || min > max {
+		return PortRange{}, errors.Errorf("invalid port range %q", value)
+	}
+	return PortRange{Min: min, Max: max}, nil
+}
+
+// Allowlist restricts the destinations the proxy dials.
+// A destination is allowed if it was resolved from a registered or discovered target
+// and AllowResolved is set, or if its host matches a CIDR or a hostname glob and its port
+// is in a port range. Empty CIDRs and hostname globs match every host, empty port ranges every port.
+// Hostnames are never resolved, they can only match hostname globs.
+type Allowlist struct {
+	CIDRs         []*net.IPNet
+	HostnameGlobs []string
+	PortRanges    []PortRange
+	AllowResolved bool
+}
+
+// NewAllowlist parses lists of CIDRs, hostname globs like *.svc.cluster.local and port ranges
+func NewAllowlist(cidrs []string, hostnameGlobs []string, portRanges []string, allowResolved bool) (*Allowlist, error) {
+	allowlist := &Allowlist{HostnameGlobs: hostnameGlobs, AllowResolved: allowResolved}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR %q", cidr)
+		}
+		allowlist.CIDRs = append(allowlist.CIDRs, ipNet)
+	}
+	for _, glob := range hostnameGlobs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid hostname glob %q", glob)
+		}
+	}
+	for _, value := range portRanges {
+		portRange, err := ParsePortRange(value)
+		if err != nil {
+			return nil, err
+		}
+		allowlist.PortRanges = append(allowlist.PortRanges, portRange)
+	}
+	return allowlist, nil
+}
+
+func (a *Allowlist) hostAllowed(host string) bool {
+	if len(a.CIDRs) == 0 && len(a.HostnameGlobs) == 0 {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, cidr := range a.CIDRs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(host)
+	for _, glob := range a.HostnameGlobs {
+		if matched, _ := path.Match(strings.ToLower(glob), host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Allowlist) portAllowed(port int) bool {
+	if len(a.PortRanges) == 0 {
+		return true
+	}
+	for _, portRange := range a.PortRanges {
+		if port >= portRange.Min && port <= portRange.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns a PermissionDenied error if the address may not be dialed.
+// resolved is true when the address comes from a registered or discovered target.
+func (a *Allowlist) Check(address string, resolved bool) error {
+	if resolved && a.AllowResolved {
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "destination %q is not allowed: should be host:port", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "destination %q is not allowed: invalid port", address)
+	}
+	if !a.hostAllowed(host) {
+		return status.Errorf(codes.PermissionDenied, "destination %q is not allowed: host not in allowlist", address)
+	}
+	if !a.portAllowed(port) {
+		return status.Errorf(codes.PermissionDenied, "destination %q is not allowed: port not in allowlist", address)
+	}
+	return nil
+}
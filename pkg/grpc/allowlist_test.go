@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePortRange(t *testing.T) {
+	portRange, err := ParsePortRange("9000")
+	require.NoError(t, err)
+	assert.Equal(t, PortRange{Min: 9000, Max: 9000}, portRange)
+	portRange, err = ParsePortRange("9000-9100")
+	require.NoError(t, err)
+	assert.Equal(t, PortRange{Min: 9000, Max: 9100}, portRange)
+	_, err = ParsePortRange("9100-9000")
+	assert.Error(t, err)
+	_, err = ParsePortRange("http")
+	assert.Error(t, err)
+}
+
+func TestAllowlistCheck(t *testing.T) {
+	allowlist, err := NewAllowlist([]string{"10.0.0.0/8", "fd00::/8"}, []string{"*.svc.cluster.local"}, []string{"9000-9100"}, true)
+	require.NoError(t, err)
+
+	assert.NoError(t, allowlist.Check("10.1.2.3:9000", false))
+	assert.NoError(t, allowlist.Check("[fd00::1]:9100", false))
+	assert.NoError(t, allowlist.Check("api.ns.svc.cluster.local:9050", false))
+	assert.NoError(t, allowlist.Check("169.254.169.254:80", true))
+
+	for _, address := range []string{
+		"169.254.169.254:9000",
+		"10.1.2.3:22",
+		"metadata.google.internal:9000",
+		"unix:///var/run/docker.sock",
+		"10.1.2.3",
+	} {
+		err := allowlist.Check(address, false)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), address)
+	}
+}
+
+func TestAllowlistBeforeDial(t *testing.T) {
+	c := NewChannelzProxyServer(zap.NewNop())
+	allowlist, err := NewAllowlist([]string{"10.0.0.0/8"}, nil, nil, true)
+	require.NoError(t, err)
+	c.SetAllowlist(allowlist)
+
+	_, err = c.GetChannel(context.Background(), "127.0.0.1:9000", 1)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Empty(t, c.ListConnections())
+}
@@ -0,0 +1,170 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Side of a socket holding a certificate
+const (
+	// CertificateLocal is a certificate presented by the target
+	CertificateLocal = "local"
+	// CertificateRemote is a certificate presented by the peer of the target
+	CertificateRemote = "remote"
+)
+
+// CertificateSocket is a socket using a certificate
+type CertificateSocket struct {
+	SocketId int64  `json:"socket_id"`
+	Name     string `json:"name,omitempty"`
+	// Owner is the server, channel or subchannel of the socket, like subchannel:4
+	Owner  string `json:"owner"`
+	Side   string `json:"side"`
+	Local  string `json:"local,omitempty"`
+	Remote string `json:"remote,omitempty"`
+}
+
+// CertificateReport is a distinct certificate found on the sockets of a target
+type CertificateReport struct {
+	*Certificate
+	// Remaining is the time left before the certificate expires, negative once expired
+	Remaining   string `json:"remaining,omitempty"`
+	RemainingMs int64  `json:"remaining_ms"`
+	// Expiring is set when the certificate expires within the requested window
+	Expiring bool                `json:"expiring"`
+	Sockets  []CertificateSocket `json:"sockets"`
+}
+
+// ownedSocket is a socket with the entity it belongs to
+type ownedSocket struct {
+	owner  string
+	socket SocketResult
+}
+
+// certificateReports groups the certificates of sockets by fingerprint.
+// Reports are sorted by expiration, certificates which could not be parsed come last.
+func certificateReports(sockets []ownedSocket, now time.Time, expiringWithin time.Duration) []CertificateReport {
+	reports := make(map[string]*CertificateReport)
+	var fingerprints []string
+	add := func(cert *Certificate, side string, owner string, socket SocketResult, decoded DecodedSocket) {
+		if cert == nil {
+			return
+		}
+		report, ok := reports[cert.Fingerprint]
+		if !ok {
+			report = &CertificateReport{Certificate: cert, Sockets: []CertificateSocket{}}
+			if cert.Error == "" {
+				remaining := cert.NotAfter.Sub(now)
+				report.Remaining = remaining.Round(time.Second).String()
+				report.RemainingMs = remaining.Milliseconds()
+				report.Expiring = remaining < expiringWithin
+			}
+			reports[cert.Fingerprint] = report
+			fingerprints = append(fingerprints, cert.Fingerprint)
+		}
+		report.Sockets = append(report.Sockets, CertificateSocket{
+			SocketId: socket.GetRef().GetSocketId(),
+			Name:     socket.GetRef().GetName(),
+			Owner:    owner,
+			Side:     side,
+			Local:    decoded.Local,
+			Remote:   decoded.Remote,
+		})
+	}
+	for _, owned := range sockets {
+		decoded := owned.socket.Decode()
+		if decoded.Security == nil {
+			continue
+		}
+		add(decoded.Security.LocalCertificate, CertificateLocal, owned.owner, owned.socket, decoded)
+		add(decoded.Security.RemoteCertificate, CertificateRemote, owned.owner, owned.socket, decoded)
+	}
+
+	res := make([]CertificateReport, 0, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		res = append(res, *reports[fingerprint])
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if (res[i].Error == "") != (res[j].Error == "") {
+			return res[i].Error == ""
+		}
+		return res[i].NotAfter.Before(res[j].NotAfter)
+	})
+	return res
+}
+
+// channelSockets lists the sockets of a channel tree with their owner
+func channelSockets(channel *ChannelNode, seen map[int64]bool) ([]ownedSocket, []EntityError) {
+	var res []ownedSocket
+	var entityErrors []EntityError
+	var addChannel func(channel *ChannelNode)
+	var addSubchannel func(subchannel *SubchannelNode)
+	addSockets := func(owner string, refs resolvedRefs) {
+		entityErrors = append(entityErrors, refs.Errors...)
+		for _, socket := range refs.Sockets {
+			if !seen[socket.GetRef().GetSocketId()] {
+				seen[socket.GetRef().GetSocketId()] = true
+				res = append(res, ownedSocket{owner: owner, socket: socket})
+			}
+		}
+		for _, nested := range refs.NestedChannels {
+			addChannel(nested)
+		}
+		for _, subchannel := range refs.Subchannels {
+			addSubchannel(subchannel)
+		}
+	}
+	addChannel = func(channel *ChannelNode) {
+		addSockets("channel:"+strconv.FormatInt(channel.GetRef().GetChannelId(), 10), channel.resolvedRefs)
+	}
+	addSubchannel = func(subchannel *SubchannelNode) {
+		addSockets("subchannel:"+strconv.FormatInt(subchannel.GetRef().GetSubchannelId(), 10), subchannel.resolvedRefs)
+	}
+	addChannel(channel)
+	return res, entityErrors
+}
+
+// GetCertificates walks every server socket and every channel and subchannel socket of a target
+// and returns the distinct certificates they use. Certificates expiring within expiringWithin are flagged.
+// Channels which could not be walked are reported as entity errors.
+func (c *ChannelzProxyServer) GetCertificates(ctx context.Context, address string, expiringWithin time.Duration) ([]CertificateReport, []EntityError, error) {
+	channels, _, err := c.GetTopChannels(ctx, address, 0, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sockets []ownedSocket
+	var entityErrors []EntityError
+	seen := make(map[int64]bool)
+	for _, channel := range channels {
+		tree, err := c.ExpandChannel(ctx, address, channel, ExpandAll, MaxTreeDepth)
+		if err != nil {
+			entityErrors = append(entityErrors, newEntityError(channel.GetRef().GetChannelId(), err))
+			continue
+		}
+		treeSockets, treeErrors := channelSockets(tree, seen)
+		sockets = append(sockets, treeSockets...)
+		entityErrors = append(entityErrors, treeErrors...)
+	}
+
+	servers, _, err := c.GetServers(ctx, address, 0, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverNodes, err := c.ExpandServers(ctx, address, servers, Expand{Sockets: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, server := range serverNodes {
+		owner := "server:" + strconv.FormatInt(server.GetRef().GetServerId(), 10)
+		for _, socket := range server.Sockets {
+			if !seen[socket.GetRef().GetSocketId()] {
+				seen[socket.GetRef().GetSocketId()] = true
+				sockets = append(sockets, ownedSocket{owner: owner, socket: socket})
+			}
+		}
+		entityErrors = append(entityErrors, server.Errors...)
+	}
+	return certificateReports(sockets, time.Now(), expiringWithin), entityErrors, nil
+}
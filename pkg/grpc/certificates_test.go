@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func tlsSocket(id int64, local []byte, remote []byte) SocketResult {
+	return SocketResult{Socket: &channelzgrpc.Socket{
+		Ref: &channelzgrpc.SocketRef{SocketId: id},
+		Security: &channelzgrpc.Security{Model: &channelzgrpc.Security_Tls_{Tls: &channelzgrpc.Security_Tls{
+			LocalCertificate:  local,
+			RemoteCertificate: remote,
+		}}},
+	}}
+}
+
+func TestCertificateReports(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	serverCert := testCertificate(t, now.Add(365*24*time.Hour))
+	oldPeerCert := testCertificate(t, now.Add(24*time.Hour))
+	newPeerCert := testCertificate(t, now.Add(90*24*time.Hour))
+
+	reports := certificateReports([]ownedSocket{
+		{owner: "server:1", socket: tlsSocket(10, serverCert, nil)},
+		{owner: "server:1", socket: tlsSocket(11, serverCert, []byte("invalid"))},
+		{owner: "subchannel:4", socket: tlsSocket(12, nil, oldPeerCert)},
+		{owner: "subchannel:6", socket: tlsSocket(13, nil, newPeerCert)},
+		{owner: "subchannel:6", socket: SocketResult{Socket: &channelzgrpc.Socket{Ref: &channelzgrpc.SocketRef{SocketId: 14}}}},
+	}, now, 30*24*time.Hour)
+	require.Len(t, reports, 4)
+
+	assert.Equal(t, ParseCertificate(oldPeerCert).Fingerprint, reports[0].Fingerprint)
+	assert.True(t, reports[0].Expiring)
+	assert.Equal(t, "24h0m0s", reports[0].Remaining)
+	assert.Equal(t, (24 * time.Hour).Milliseconds(), reports[0].RemainingMs)
+	assert.Equal(t, []CertificateSocket{{SocketId: 12, Owner: "subchannel:4", Side: CertificateRemote}}, reports[0].Sockets)
+
+	assert.False(t, reports[1].Expiring)
+	assert.Equal(t, ParseCertificate(newPeerCert).Fingerprint, reports[1].Fingerprint)
+
+	assert.Equal(t, ParseCertificate(serverCert).Fingerprint, reports[2].Fingerprint)
+	require.Len(t, reports[2].Sockets, 2)
+	assert.Equal(t, int64(10), reports[2].Sockets[0].SocketId)
+	assert.Equal(t, CertificateLocal, reports[2].Sockets[1].Side)
+
+	assert.NotEmpty(t, reports[3].Error)
+	assert.False(t, reports[3].Expiring)
+}
+
+func TestChannelSockets(t *testing.T) {
+	channel := &ChannelNode{
+		ChannelResult: newChannelResult(&channelzgrpc.Channel{Ref: &channelzgrpc.ChannelRef{ChannelId: 3}}),
+		resolvedRefs: resolvedRefs{
+			Subchannels: []*SubchannelNode{{
+				Subchannel:   &channelzgrpc.Subchannel{Ref: &channelzgrpc.SubchannelRef{SubchannelId: 4}},
+				resolvedRefs: resolvedRefs{Sockets: []SocketResult{tlsSocket(8, nil, nil)}},
+			}},
+			Errors: []EntityError{{Id: 5}},
+		},
+	}
+	seen := map[int64]bool{9: true}
+	sockets, entityErrors := channelSockets(channel, seen)
+	require.Len(t, sockets, 1)
+	assert.Equal(t, "subchannel:4", sockets[0].owner)
+	assert.Len(t, entityErrors, 1)
+	assert.True(t, seen[8])
+}
+
+// vanishingChannelServer lists two top channels, the nested channel 8 of the first one is gone when it is fetched
+type vanishingChannelServer struct {
+	channelzgrpc.UnimplementedChannelzServer
+	certificate []byte
+}
+
+func (s *vanishingChannelServer) GetTopChannels(ctx context.Context, req *channelzgrpc.GetTopChannelsRequest) (*channelzgrpc.GetTopChannelsResponse, error) {
+	return &channelzgrpc.GetTopChannelsResponse{End: true, Channel: []*channelzgrpc.Channel{
+		{Ref: &channelzgrpc.ChannelRef{ChannelId: 1}, ChannelRef: []*channelzgrpc.ChannelRef{{ChannelId: 8}}},
+		{Ref: &channelzgrpc.ChannelRef{ChannelId: 2}, SocketRef: []*channelzgrpc.SocketRef{{SocketId: 3}}},
+	}}, nil
+}
+
+func (s *vanishingChannelServer) GetChannel(ctx context.Context, req *channelzgrpc.GetChannelRequest) (*channelzgrpc.GetChannelResponse, error) {
+	return nil, status.Error(codes.NotFound, "channel 8 not found")
+}
+
+func (s *vanishingChannelServer) GetSocket(ctx context.Context, req *channelzgrpc.GetSocketRequest) (*channelzgrpc.GetSocketResponse, error) {
+	socket := tlsSocket(req.SocketId, s.certificate, nil)
+	return &channelzgrpc.GetSocketResponse{Socket: socket.Socket}, nil
+}
+
+func (s *vanishingChannelServer) GetServers(ctx context.Context, req *channelzgrpc.GetServersRequest) (*channelzgrpc.GetServersResponse, error) {
+	return &channelzgrpc.GetServersResponse{End: true}, nil
+}
+
+func TestGetCertificatesChannelGone(t *testing.T) {
+	upstream := &vanishingChannelServer{certificate: testCertificate(t, time.Now().Add(time.Hour))}
+	address := ServeTestServer(t, func(s grpc.ServiceRegistrar) {
+		channelzgrpc.RegisterChannelzServer(s, upstream)
+	})
+
+	c := NewChannelzProxyServer(zap.NewNop())
+	defer c.connCache.closeAll()
+	reports, entityErrors, err := c.GetCertificates(context.Background(), address, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []EntityError{{Id: 8, Code: "NotFound", Message: "channel 8 not found"}}, entityErrors)
+	require.Len(t, reports, 1)
+	assert.Equal(t, []CertificateSocket{{SocketId: 3, Owner: "channel:2", Side: CertificateLocal}}, reports[0].Sockets)
+	assert.True(t, reports[0].Expiring)
+}
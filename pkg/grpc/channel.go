@@ -2,49 +2,65 @@ package grpc
 
 import (
 	"context"
-	"strings"
 
 	"go.uber.org/zap"
 	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type ChannelResult struct {
 	*channelzgrpc.Channel
 	LbPolicy string `json:"lb_policy"`
+	// LbPolicyChain lists the nested policies, from the top-level policy to the leaf
+	LbPolicyChain []string `json:"lb_policy_chain,omitempty"`
+	// LbPolicySwitchTime is the time of the last switch of the top-level policy
+	LbPolicySwitchTime *timestamppb.Timestamp `json:"lb_policy_switch_time,omitempty"`
 }
 
 func extractLbPolicyFromEvents(events []*channelzgrpc.ChannelTraceEvent) string {
-	for _, event := range events {
-		goLbPolicyMessage := "Channel switches to new LB policy \""
-		if strings.HasPrefix(event.Description, goLbPolicyMessage) {
-			lbPolicy := strings.TrimRight(event.Description[len(goLbPolicyMessage):], "\"")
-			return lbPolicy
-		}
+	return detectLbPolicy(ParseTraceEvents(events), false).Policy
+}
+
+func newChannelResult(channel *channelzgrpc.Channel) ChannelResult {
+	lbPolicy := DetectLbPolicy(channel)
+	if lbPolicy.Evicted {
+		lbPolicy.Policy = LbPolicyEvicted
+	}
+	return ChannelResult{
+		Channel:            channel,
+		LbPolicy:           lbPolicy.Policy,
+		LbPolicyChain:      lbPolicy.Chain,
+		LbPolicySwitchTime: lbPolicy.SwitchTime,
 	}
-	return ""
 }
 
-func (c *ChannelzProxyServer) GetTopChannels(ctx context.Context, address string, startChannelId int64) ([]ChannelResult, error) {
+// GetTopChannels follows GetTopChannels pages from startChannelId until the end or until limit channels are collected.
+// A limit of 0 returns all channels.
+func (c *ChannelzProxyServer) GetTopChannels(ctx context.Context, address string, startChannelId int64, limit int64, pageSize int64) ([]ChannelResult, Page, error) {
 	clt, err := c.getChannelClient(address)
 	if err != nil {
-		return nil, err
+		return nil, Page{}, err
+	}
+	fetch := func(startId int64, maxResults int64) ([]*channelzgrpc.Channel, bool, error) {
+		req := &channelzgrpc.GetTopChannelsRequest{StartChannelId: startId, MaxResults: maxResults}
+		resp, err := clt.GetTopChannels(ctx, req)
+		if err != nil {
+			c.logger.Warn("Error getting top channels", zap.Error(err))
+			return nil, false, err
+		}
+		return resp.Channel, resp.End, nil
 	}
-	req := &channelzgrpc.GetTopChannelsRequest{StartChannelId: startChannelId}
-	resp, err := clt.GetTopChannels(ctx, req)
+	channels, page, err := paginate(startChannelId, limit, pageSize, fetch, func(channel *channelzgrpc.Channel) int64 {
+		return channel.GetRef().GetChannelId()
+	})
 	if err != nil {
-		c.logger.Warn("Error getting top channels", zap.Error(err))
-		return nil, err
+		return nil, Page{}, err
 	}
 	results := make([]ChannelResult, 0)
-	for _, channel := range resp.Channel {
-		lbPolicy := extractLbPolicyFromEvents(channel.Data.Trace.Events)
-		channelResult := ChannelResult{
-			Channel:  channel,
-			LbPolicy: lbPolicy,
-		}
-		results = append(results, channelResult)
+	for _, channel := range channels {
+		results = append(results, newChannelResult(channel))
 	}
-	return results, err
+	return results, page, nil
 }
 
 func (c *ChannelzProxyServer) GetChannel(ctx context.Context, address string, channelId int64) (*ChannelResult, error) {
@@ -58,11 +74,7 @@ func (c *ChannelzProxyServer) GetChannel(ctx context.Context, address string, ch
 		c.logger.Warn("Error getting top channels", zap.Error(err))
 		return nil, err
 	}
-	lbPolicy := extractLbPolicyFromEvents(resp.Channel.Data.Trace.Events)
-	channelResult := ChannelResult{
-		Channel:  resp.Channel,
-		LbPolicy: lbPolicy,
-	}
+	channelResult := newChannelResult(resp.Channel)
 	return &channelResult, err
 }
 
@@ -80,20 +92,20 @@ func (c *ChannelzProxyServer) GetSubchannel(ctx context.Context, address string,
 	return resp.Subchannel, nil
 }
 
-func (c *ChannelzProxyServer) GetSubchannels(ctx context.Context, address string, subchannelIds []int64) ([]*channelzgrpc.Subchannel, error) {
+// GetSubchannels fetches subchannels in parallel.
+// Subchannels that could not be fetched are reported in the returned entity errors.
+func (c *ChannelzProxyServer) GetSubchannels(ctx context.Context, address string, subchannelIds []int64) ([]*channelzgrpc.Subchannel, []EntityError, error) {
 	clt, err := c.getChannelClient(address)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	res := make([]*channelzgrpc.Subchannel, 0)
-	for _, subchannelId := range subchannelIds {
+	return fanOut(ctx, subchannelIds, c.getFanOutConcurrency(), func(ctx context.Context, subchannelId int64) (*channelzgrpc.Subchannel, error) {
 		req := &channelzgrpc.GetSubchannelRequest{SubchannelId: subchannelId}
 		resp, err := clt.GetSubchannel(ctx, req)
 		if err != nil {
-			c.logger.Warn("Error getting top channels", zap.Error(err))
+			c.logger.Warn("Error getting subchannel", zap.Int64("subchannelId", subchannelId), zap.Error(err))
 			return nil, err
 		}
-		res = append(res, resp.Subchannel)
-	}
-	return res, nil
+		return resp.Subchannel, nil
+	})
 }
@@ -24,12 +24,14 @@ func TestGetTopChannels(t *testing.T) {
 	assert.NoError(t, err, "zap")
 
 	ctx, cancel := context.WithCancel(context.Background())
-	createTestGrpcServer(t, ctx)
+	address := createTestGrpcServer(t, ctx)
 
 	c := NewChannelzProxyServer(logger)
+	defer c.connCache.closeAll()
 
-	channels, err := c.GetTopChannels(ctx, "localhost:7654", 0)
+	channels, page, err := c.GetTopChannels(ctx, address, 0, 0, 0)
 	assert.NoError(t, err, "GetTopChannels")
 	assert.Equal(t, len(channels), 1)
+	assert.True(t, page.End)
 	cancel()
 }
@@ -2,105 +2,318 @@ package grpc
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
+// HostResolver maps a host, like a pod name, to a channelz address.
+// It returns false if the host is unknown.
+type HostResolver func(host string) (string, bool)
+
+// AddressChecker returns true if an address belongs to a known target, like a registered target or a discovered pod
+type AddressChecker func(address string) bool
+
 type ChannelzProxyServer struct {
 	logger *zap.Logger
 
-	cachedConn map[string]*grpc.ClientConn
+	securityMu      sync.RWMutex
+	defaultSecurity SecurityConfig
+	targetSecurity  map[string]SecurityConfig
+
+	resolversMu sync.RWMutex
+	resolvers   []HostResolver
+
+	knownAddressesMu sync.RWMutex
+	knownAddresses   []AddressChecker
+
+	allowlistMu sync.RWMutex
+	allowlist   *Allowlist
+
+	limitsMu      sync.Mutex
+	defaultLimits TargetLimits
+	targetLimits  map[string]TargetLimits
+	limiters      map[string]*targetLimiter
+
+	connCache *connCache
+
+	fanOutConcurrency atomic.Int64
 }
 
 func NewChannelzProxyServer(logger *zap.Logger) *ChannelzProxyServer {
-	return &ChannelzProxyServer{
-		logger:     logger.Named("ChannelzProxyServer"),
-		cachedConn: make(map[string]*grpc.ClientConn),
+	logger = logger.Named("ChannelzProxyServer")
+	c := &ChannelzProxyServer{
+		logger:          logger,
+		defaultSecurity: InsecureSecurityConfig,
+		targetSecurity:  make(map[string]SecurityConfig),
+		targetLimits:    make(map[string]TargetLimits),
+		limiters:        make(map[string]*targetLimiter),
+		connCache:       newConnCache(logger, defaultConnCacheSize, defaultConnCacheIdleTTL),
+	}
+	c.connCache.onClose = c.removeLimiter
+	c.fanOutConcurrency.Store(defaultFanOutConcurrency)
+	return c
+}
+
+// SetFanOutConcurrency sets the maximum number of parallel upstream calls
+// used when fetching multiple subchannels or sockets
+func (c *ChannelzProxyServer) SetFanOutConcurrency(concurrency int) {
+	c.fanOutConcurrency.Store(int64(concurrency))
+}
+
+func (c *ChannelzProxyServer) getFanOutConcurrency() int {
+	return int(c.fanOutConcurrency.Load())
+}
+
+// SetDefaultSecurity sets the security used for addresses without a specific configuration
+func (c *ChannelzProxyServer) SetDefaultSecurity(security SecurityConfig) {
+	c.securityMu.Lock()
+	defer c.securityMu.Unlock()
+	c.defaultSecurity = security
+}
+
+// DefaultSecurity returns the security used for addresses without a specific configuration
+func (c *ChannelzProxyServer) DefaultSecurity() SecurityConfig {
+	c.securityMu.RLock()
+	defer c.securityMu.RUnlock()
+	return c.defaultSecurity
+}
+
+// SetTargetSecurity sets the security used to connect to a specific address
+func (c *ChannelzProxyServer) SetTargetSecurity(address string, security SecurityConfig) {
+	c.securityMu.Lock()
+	defer c.securityMu.Unlock()
+	c.targetSecurity[address] = security
+}
+
+// TargetSecurity returns the security set for a specific address
+func (c *ChannelzProxyServer) TargetSecurity(address string) (SecurityConfig, bool) {
+	c.securityMu.RLock()
+	defer c.securityMu.RUnlock()
+	security, ok := c.targetSecurity[address]
+	return security, ok
+}
+
+// RemoveTargetSecurity makes the address use the default security again
+func (c *ChannelzProxyServer) RemoveTargetSecurity(address string) {
+	c.securityMu.Lock()
+	defer c.securityMu.Unlock()
+	delete(c.targetSecurity, address)
+}
+
+// AddHostResolver registers a resolver tried, in registration order, on every host before dialing.
+// Hosts unknown to all resolvers are used as addresses.
+func (c *ChannelzProxyServer) AddHostResolver(resolver HostResolver) {
+	c.resolversMu.Lock()
+	defer c.resolversMu.Unlock()
+	c.resolvers = append(c.resolvers, resolver)
+}
+
+// ResolveHost returns the channelz address of a host
+func (c *ChannelzProxyServer) ResolveHost(host string) string {
+	address, _ := c.resolve(host)
+	return address
+}
+
+// resolve returns the address of a host and true if a resolver knows the host
+func (c *ChannelzProxyServer) resolve(host string) (string, bool) {
+	c.resolversMu.RLock()
+	defer c.resolversMu.RUnlock()
+	for _, resolver := range c.resolvers {
+		if address, ok := resolver(host); ok {
+			return address, true
+		}
 	}
+	return host, false
 }
 
-func (c *ChannelzProxyServer) getChannelClient(address string) (channelzgrpc.ChannelzClient, error) {
-	dialOptions := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+// SetAllowlist restricts the destinations the proxy dials, nil allows every destination
+func (c *ChannelzProxyServer) SetAllowlist(allowlist *Allowlist) {
+	c.allowlistMu.Lock()
+	defer c.allowlistMu.Unlock()
+	c.allowlist = allowlist
+}
+
+func (c *ChannelzProxyServer) checkDestination(host string, address string, resolved bool) error {
+	c.allowlistMu.RLock()
+	allowlist := c.allowlist
+	c.allowlistMu.RUnlock()
+	if allowlist == nil {
+		return nil
+	}
+	if err := allowlist.Check(address, resolved); err != nil {
+		c.logger.Warn("Rejected destination", zap.String("host", host), zap.String("address", address), zap.Error(err))
+		rejectedDestinations.Inc()
+		return err
 	}
-	c.logger.Info("Connecting to grpc", zap.String("address", address))
-	conn, ok := c.cachedConn[address]
+	return nil
+}
+
+// SetConnectionCacheLimits sets the maximum number of cached connections and
+// the duration after which an unused connection is closed
+func (c *ChannelzProxyServer) SetConnectionCacheLimits(maxSize int, idleTTL time.Duration) {
+	c.connCache.setLimits(maxSize, idleTTL)
+}
+
+// RunConnectionJanitor closes idle connections until the context is done
+func (c *ChannelzProxyServer) RunConnectionJanitor(ctx context.Context) {
+	c.connCache.run(ctx, time.Minute)
+}
+
+// ListConnections returns the cached upstream connections
+func (c *ChannelzProxyServer) ListConnections() []ConnectionInfo {
+	return c.connCache.list()
+}
+
+// CloseConnections closes cached connections to the host and returns the number of closed connections
+func (c *ChannelzProxyServer) CloseConnections(host string) int {
+	return c.connCache.closeAddress(c.ResolveHost(host))
+}
+
+func (c *ChannelzProxyServer) securityFor(address string) SecurityConfig {
+	c.securityMu.RLock()
+	defer c.securityMu.RUnlock()
+	security, ok := c.targetSecurity[address]
 	if ok {
-		return channelzgrpc.NewChannelzClient(conn), nil
+		return security
+	}
+	return c.defaultSecurity
+}
+
+func (c *ChannelzProxyServer) getChannelClient(host string) (channelzgrpc.ChannelzClient, error) {
+	address, resolved := c.resolve(host)
+	if err := c.checkDestination(host, address, resolved); err != nil {
+		return nil, err
 	}
-	conn, err := grpc.Dial(address, dialOptions...)
+	security := c.securityFor(address)
+	cacheKey := address + "|" + security.cacheKey()
+	conn, err := c.connCache.get(cacheKey, address, security, func() (*grpc.ClientConn, error) {
+		c.logger.Info("Connecting to grpc", zap.String("address", address), zap.Bool("insecure", security.Insecure))
+		creds, err := security.transportCredentials(address)
+		if err != nil {
+			c.logger.Warn("Error loading transport credentials", zap.String("address", address), zap.Error(err))
+			dialFailures.WithLabelValues(c.addressLabel(address)).Inc()
+			return nil, err
+		}
+		dialOptions := []grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			// Rpcs rejected by the limits never reach the instrumentation of upstream rpcs
+			grpc.WithChainUnaryInterceptor(c.limitsInterceptor(address), c.instrumentationInterceptor(address)),
+		}
+		if security.Credentials != nil {
+			dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(security.Credentials))
+		}
+		conn, err := grpc.Dial(address, dialOptions...)
+		if err != nil {
+			c.logger.Warn("Error dialing", zap.String("address", address), zap.Error(err))
+			dialFailures.WithLabelValues(c.addressLabel(address)).Inc()
+			return nil, err
+		}
+		return conn, nil
+	})
 	if err != nil {
-		c.logger.Warn("Error dialing", zap.String("address", address), zap.Error(err))
 		return nil, err
 	}
-	c.cachedConn[address] = conn
-	client := channelzgrpc.NewChannelzClient(conn)
-	return client, nil
+	return channelzgrpc.NewChannelzClient(conn), nil
+}
+
+// GetServers follows GetServers pages from startServerId until the end or until limit servers are collected.
+// A limit of 0 returns all servers.
+func (c *ChannelzProxyServer) GetServers(ctx context.Context, address string, startServerId int64, limit int64, pageSize int64) ([]*channelzgrpc.Server, Page, error) {
+	clt, err := c.getChannelClient(address)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	fetch := func(startId int64, maxResults int64) ([]*channelzgrpc.Server, bool, error) {
+		req := &channelzgrpc.GetServersRequest{StartServerId: startId, MaxResults: maxResults}
+		resp, err := clt.GetServers(ctx, req)
+		if err != nil {
+			c.logger.Warn("Error getting servers", zap.Error(err))
+			return nil, false, err
+		}
+		return resp.Server, resp.End, nil
+	}
+	return paginate(startServerId, limit, pageSize, fetch, func(server *channelzgrpc.Server) int64 {
+		return server.GetRef().GetServerId()
+	})
 }
 
-func (c *ChannelzProxyServer) GetServers(ctx context.Context, address string, startServerId int64) ([]*channelzgrpc.Server, error) {
+func (c *ChannelzProxyServer) GetServer(ctx context.Context, address string, serverId int64) (*channelzgrpc.Server, error) {
 	clt, err := c.getChannelClient(address)
 	if err != nil {
 		return nil, err
 	}
-	req := &channelzgrpc.GetServersRequest{StartServerId: startServerId}
-	resp, err := clt.GetServers(ctx, req)
+	req := &channelzgrpc.GetServerRequest{ServerId: serverId}
+	resp, err := clt.GetServer(ctx, req)
 	if err != nil {
-		c.logger.Warn("Error getting servers", zap.Error(err))
+		c.logger.Warn("Error getting server", zap.Error(err))
 		return nil, err
 	}
 	return resp.Server, nil
 }
 
 func (c *ChannelzProxyServer) getServerSocketIds(ctx context.Context, clt channelzgrpc.ChannelzClient, serverId int64, startSocketId int64) ([]int64, error) {
-	socketIds := make([]int64, 0)
-	currentStart := startSocketId
-	for {
-		serverSocketReq := &channelzgrpc.GetServerSocketsRequest{ServerId: serverId, StartSocketId: currentStart}
+	fetch := func(startId int64, maxResults int64) ([]*channelzgrpc.SocketRef, bool, error) {
+		serverSocketReq := &channelzgrpc.GetServerSocketsRequest{ServerId: serverId, StartSocketId: startId, MaxResults: maxResults}
 		serverSocketResp, err := clt.GetServerSockets(ctx, serverSocketReq)
 		if err != nil {
 			c.logger.Warn("Error getting server sockets", zap.Error(err))
-			return nil, err
-		}
-		nextSocketId := int64(0)
-		for _, socketRef := range serverSocketResp.SocketRef {
-			socketId := socketRef.GetSocketId()
-			socketIds = append(socketIds, socketId)
-			if socketId > nextSocketId {
-				nextSocketId = socketId
-			}
+			return nil, false, err
 		}
-		if nextSocketId == 0 || nextSocketId < startSocketId || serverSocketResp.End {
-			return socketIds, nil
-		}
-		startSocketId = nextSocketId
+		return serverSocketResp.SocketRef, serverSocketResp.End, nil
+	}
+	socketRefs, _, err := paginate(startSocketId, 0, 0, fetch, func(socketRef *channelzgrpc.SocketRef) int64 {
+		return socketRef.GetSocketId()
+	})
+	if err != nil {
+		return nil, err
+	}
+	socketIds := make([]int64, 0, len(socketRefs))
+	for _, socketRef := range socketRefs {
+		socketIds = append(socketIds, socketRef.GetSocketId())
 	}
+	return socketIds, nil
 }
 
-func (c *ChannelzProxyServer) GetServerSockets(ctx context.Context, address string, serverId int64, startSocketId int64) ([]*channelzgrpc.Socket, error) {
+// GetServerSockets fetches all sockets of a server in parallel.
+// Sockets that could not be fetched are reported in the returned entity errors.
+func (c *ChannelzProxyServer) GetServerSockets(ctx context.Context, address string, serverId int64, startSocketId int64) ([]*channelzgrpc.Socket, []EntityError, error) {
 	clt, err := c.getChannelClient(address)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	socketIds, err := c.getServerSocketIds(ctx, clt, serverId, startSocketId)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return c.getSockets(ctx, clt, socketIds)
+}
+
+// GetSockets fetches the sockets with the given ids in parallel.
+// Sockets that could not be fetched are reported in the returned entity errors.
+func (c *ChannelzProxyServer) GetSockets(ctx context.Context, address string, socketIds []int64) ([]*channelzgrpc.Socket, []EntityError, error) {
+	clt, err := c.getChannelClient(address)
+	if err != nil {
+		return nil, nil, err
 	}
-	sockets := make([]*channelzgrpc.Socket, 0)
-	for _, socketId := range socketIds {
-		c.logger.Info("Requesting socketId", zap.Int64("socketId", socketId))
+	return c.getSockets(ctx, clt, socketIds)
+}
+
+func (c *ChannelzProxyServer) getSockets(ctx context.Context, clt channelzgrpc.ChannelzClient, socketIds []int64) ([]*channelzgrpc.Socket, []EntityError, error) {
+	return fanOut(ctx, socketIds, c.getFanOutConcurrency(), func(ctx context.Context, socketId int64) (*channelzgrpc.Socket, error) {
+		c.logger.Debug("Requesting socketId", zap.Int64("socketId", socketId))
 		socketReq := &channelzgrpc.GetSocketRequest{SocketId: socketId}
 		socketResp, err := clt.GetSocket(ctx, socketReq)
 		if err != nil {
-			c.logger.Warn("Error getting socket", zap.Error(err))
+			c.logger.Warn("Error getting socket", zap.Int64("socketId", socketId), zap.Error(err))
 			return nil, err
 		}
-		sockets = append(sockets, socketResp.Socket)
-	}
-	return sockets, nil
+		return socketResp.Socket, nil
+	})
 }
 
 func (c *ChannelzProxyServer) GetSocket(ctx context.Context, address string, socketId int64) (*channelzgrpc.Socket, error) {
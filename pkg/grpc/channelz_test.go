@@ -2,30 +2,38 @@ package grpc
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	channelz "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/reflection"
 )
 
-func createTestGrpcServer(t *testing.T, ctx context.Context) {
+// createTestGrpcServer starts a channelz server on a random port and returns its address
+func createTestGrpcServer(t *testing.T, ctx context.Context) string {
 	t.Log("Start grpc server")
-	channelzListener, err := net.Listen("tcp", fmt.Sprintf("localhost:7654"))
-	if err != nil {
-		log.Fatalf("Error listening for channelz server %v", err)
-	}
-	channelzServer := grpc.NewServer()
-	channelz.RegisterChannelzServiceToServer(channelzServer)
-	reflection.Register(channelzServer)
-	go func() {
-		defer channelzListener.Close()
-		t.Log("Starting channelz test server")
-		err = channelzServer.Serve(channelzListener)
-		assert.NoError(t, err, "Channel Serve")
-	}()
+	return ServeTestServer(t, func(s grpc.ServiceRegistrar) {
+		channelz.RegisterChannelzServiceToServer(s)
+		reflection.Register(s.(reflection.GRPCServer))
+	})
+}
+
+func TestResolveHost(t *testing.T) {
+	c := NewChannelzProxyServer(zap.NewNop())
+	assert.Equal(t, "10.0.0.1:80", c.ResolveHost("10.0.0.1:80"))
+
+	c.AddHostResolver(func(host string) (string, bool) {
+		if host == "pod-a" {
+			return "10.0.0.2:80", true
+		}
+		return "", false
+	})
+	c.AddHostResolver(func(host string) (string, bool) {
+		return "10.0.0.3:80", host == "pod-a" || host == "pod-b"
+	})
+	assert.Equal(t, "10.0.0.2:80", c.ResolveHost("pod-a"))
+	assert.Equal(t, "10.0.0.3:80", c.ResolveHost("pod-b"))
+	assert.Equal(t, "pod-c", c.ResolveHost("pod-c"))
 }
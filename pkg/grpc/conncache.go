@@ -0,0 +1,206 @@
+package grpc
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	defaultConnCacheSize    = 100
+	defaultConnCacheIdleTTL = 10 * time.Minute
+)
+
+// ConnectionInfo describes a cached upstream connection
+type ConnectionInfo struct {
+	Address  string    `json:"address"`
+	Insecure bool      `json:"insecure"`
+	State    string    `json:"state"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+type connCacheEntry struct {
+	key      string
+	address  string
+	security SecurityConfig
+	conn     *grpc.ClientConn
+	created  time.Time
+	lastUsed time.Time
+	element  *list.Element
+}
+
+// connCache is a bounded LRU cache of grpc connections.
+// Connections are closed when evicted, idle for longer than idleTTL or in TRANSIENT_FAILURE.
+type connCache struct {
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	maxSize int
+	idleTTL time.Duration
+	entries map[string]*connCacheEntry
+	// Most recently used entries are at the front
+	lru *list.List
+	// onClose is called when the last connection to an address is removed
+	onClose func(address string)
+}
+
+func newConnCache(logger *zap.Logger, maxSize int, idleTTL time.Duration) *connCache {
+	return &connCache{
+		logger:  logger,
+		maxSize: maxSize,
+		idleTTL: idleTTL,
+		entries: make(map[string]*connCacheEntry),
+		lru:     list.New(),
+	}
+}
+
+func (cc *connCache) setLimits(maxSize int, idleTTL time.Duration) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.maxSize = maxSize
+	cc.idleTTL = idleTTL
+	cc.evictOverflow()
+}
+
+// get returns the cached connection for the key or creates it with dial
+func (cc *connCache) get(key string, address string, security SecurityConfig, dial func() (*grpc.ClientConn, error)) (*grpc.ClientConn, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	now := time.Now()
+	entry, ok := cc.entries[key]
+	if ok {
+		if entry.conn.GetState() != connectivity.TransientFailure {
+			entry.lastUsed = now
+			cc.lru.MoveToFront(entry.element)
+			return entry.conn, nil
+		}
+		cc.logger.Info("Closing connection in transient failure", zap.String("address", address))
+		cc.remove(entry)
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	entry = &connCacheEntry{
+		key:      key,
+		address:  address,
+		security: security,
+		conn:     conn,
+		created:  now,
+		lastUsed: now,
+	}
+	entry.element = cc.lru.PushFront(entry)
+	cc.entries[key] = entry
+	cc.evictOverflow()
+	connCacheSize.Set(float64(len(cc.entries)))
+	return conn, nil
+}
+
+func (cc *connCache) remove(entry *connCacheEntry) {
+	cc.lru.Remove(entry.element)
+	delete(cc.entries, entry.key)
+	connCacheSize.Set(float64(len(cc.entries)))
+	if err := entry.conn.Close(); err != nil {
+		cc.logger.Warn("Error closing connection", zap.String("address", entry.address), zap.Error(err))
+	}
+	if cc.onClose == nil {
+		return
+	}
+	for _, other := range cc.entries {
+		if other.address == entry.address {
+			return
+		}
+	}
+	cc.onClose(entry.address)
+}
+
+func (cc *connCache) evictOverflow() {
+	for cc.maxSize > 0 && cc.lru.Len() > cc.maxSize {
+		oldest := cc.lru.Back().Value.(*connCacheEntry)
+		cc.logger.Info("Evicting connection", zap.String("address", oldest.address))
+		cc.remove(oldest)
+	}
+}
+
+func (cc *connCache) evictIdle() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.idleTTL <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-cc.idleTTL)
+	for element := cc.lru.Back(); element != nil; {
+		entry := element.Value.(*connCacheEntry)
+		element = element.Prev()
+		if entry.lastUsed.After(deadline) {
+			// Entries are sorted by last use, all remaining ones are more recent
+			return
+		}
+		cc.logger.Info("Closing idle connection", zap.String("address", entry.address))
+		cc.remove(entry)
+	}
+}
+
+func (cc *connCache) list() []ConnectionInfo {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	res := make([]ConnectionInfo, 0, len(cc.entries))
+	for _, entry := range cc.entries {
+		res = append(res, ConnectionInfo{
+			Address:  entry.address,
+			Insecure: entry.security.Insecure,
+			State:    entry.conn.GetState().String(),
+			Created:  entry.created,
+			LastUsed: entry.lastUsed,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+	return res
+}
+
+// closeAddress closes all connections to the address and returns the number of closed connections
+func (cc *connCache) closeAddress(address string) int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	closed := 0
+	for _, entry := range cc.entries {
+		if entry.address == address {
+			cc.remove(entry)
+			closed++
+		}
+	}
+	return closed
+}
+
+func (cc *connCache) closeAll() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, entry := range cc.entries {
+		cc.remove(entry)
+	}
+}
+
+// run evicts idle connections until the context is done and closes all connections on exit
+func (cc *connCache) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			cc.closeAll()
+			return
+		case <-ticker.C:
+			cc.evictIdle()
+		}
+	}
+}
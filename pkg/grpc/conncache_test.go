@@ -0,0 +1,62 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func getTestConn(t *testing.T, cc *connCache, address string) *grpc.ClientConn {
+	conn, err := cc.get(address, address, InsecureSecurityConfig, func() (*grpc.ClientConn, error) {
+		return grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	})
+	require.NoError(t, err)
+	return conn
+}
+
+func TestConnCacheLRU(t *testing.T) {
+	cc := newConnCache(zap.NewNop(), 2, time.Hour)
+	defer cc.closeAll()
+
+	first := getTestConn(t, cc, "localhost:1")
+	getTestConn(t, cc, "localhost:2")
+	// Refresh first, second becomes the least recently used
+	assert.Same(t, first, getTestConn(t, cc, "localhost:1"))
+	getTestConn(t, cc, "localhost:3")
+
+	connections := cc.list()
+	require.Len(t, connections, 2)
+	assert.Equal(t, "localhost:1", connections[0].Address)
+	assert.Equal(t, "localhost:3", connections[1].Address)
+}
+
+func TestConnCacheIdle(t *testing.T) {
+	cc := newConnCache(zap.NewNop(), 10, time.Hour)
+	defer cc.closeAll()
+
+	conn := getTestConn(t, cc, "localhost:1")
+	getTestConn(t, cc, "localhost:2")
+	cc.entries["localhost:1"].lastUsed = time.Now().Add(-2 * time.Hour)
+
+	cc.evictIdle()
+	connections := cc.list()
+	require.Len(t, connections, 1)
+	assert.Equal(t, "localhost:2", connections[0].Address)
+	assert.Equal(t, connectivity.Shutdown, conn.GetState())
+}
+
+func TestConnCacheCloseAddress(t *testing.T) {
+	cc := newConnCache(zap.NewNop(), 10, time.Hour)
+	defer cc.closeAll()
+
+	getTestConn(t, cc, "localhost:1")
+	assert.Equal(t, 1, cc.closeAddress("localhost:1"))
+	assert.Equal(t, 0, cc.closeAddress("localhost:1"))
+	assert.Empty(t, cc.list())
+}
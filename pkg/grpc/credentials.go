@@ -0,0 +1,66 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CallCredentials are sent as metadata with every call to a channelz endpoint
+type CallCredentials struct {
+	// BearerToken is sent in the authorization header
+	BearerToken string `yaml:"bearerToken" json:"-"`
+	// BearerTokenFile is read on every call, allowing token rotation
+	BearerTokenFile string `yaml:"bearerTokenFile" json:"bearerTokenFile,omitempty"`
+	// Headers are additional metadata sent with every call
+	Headers map[string]string `yaml:"headers" json:"-"`
+}
+
+func (c *CallCredentials) cacheKey() string {
+	if c == nil {
+		return ""
+	}
+	headers := make([]string, 0, len(c.Headers))
+	for name, value := range c.Headers {
+		headers = append(headers, name+"="+value)
+	}
+	sort.Strings(headers)
+	return fmt.Sprintf("|token=%s|tokenFile=%s|headers=%s", c.BearerToken, c.BearerTokenFile, strings.Join(headers, ","))
+}
+
+func (c *CallCredentials) validate() error {
+	if c.BearerToken != "" && c.BearerTokenFile != "" {
+		return errors.New("bearerToken and bearerTokenFile are mutually exclusive")
+	}
+	return nil
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (c *CallCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	metadata := make(map[string]string, len(c.Headers)+1)
+	for name, value := range c.Headers {
+		metadata[strings.ToLower(name)] = value
+	}
+	token := c.BearerToken
+	if c.BearerTokenFile != "" {
+		content, err := os.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read bearer token file")
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if token != "" {
+		metadata["authorization"] = "Bearer " + token
+	}
+	return metadata, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+// Credentials are never sent over plaintext connections.
+func (c *CallCredentials) RequireTransportSecurity() bool {
+	return true
+}
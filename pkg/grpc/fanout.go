@@ -0,0 +1,67 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/status"
+)
+
+const defaultFanOutConcurrency = 16
+
+// EntityError is the error returned for a single entity of a fan-out request
+type EntityError struct {
+	Id      int64  `json:"id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newEntityError(id int64, err error) EntityError {
+	st, _ := status.FromError(err)
+	return EntityError{
+		Id:      id,
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}
+}
+
+// fanOut fetches all ids with at most concurrency parallel calls.
+// Results keep the order of ids, failed entities are skipped and reported as errors.
+// An error is returned only when every entity failed.
+func fanOut[T any](ctx context.Context, ids []int64, concurrency int, fetch func(ctx context.Context, id int64) (T, error)) ([]T, []EntityError, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]T, len(ids))
+	errs := make([]error, len(ids))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = fetch(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+
+	res := make([]T, 0, len(ids))
+	entityErrors := make([]EntityError, 0)
+	var firstErr error
+	for i, id := range ids {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			entityErrors = append(entityErrors, newEntityError(id, errs[i]))
+			continue
+		}
+		res = append(res, results[i])
+	}
+	if len(ids) > 0 && len(res) == 0 {
+		return nil, entityErrors, firstErr
+	}
+	return res, entityErrors, nil
+}
@@ -0,0 +1,53 @@
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFanOutPartialResults(t *testing.T) {
+	ids := []int64{1, 2, 3, 4}
+	res, entityErrors, err := fanOut(context.Background(), ids, 2, func(ctx context.Context, id int64) (int64, error) {
+		if id == 3 {
+			return 0, status.Error(codes.NotFound, "not found")
+		}
+		return id * 10, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10, 20, 40}, res)
+	assert.Equal(t, []EntityError{{Id: 3, Code: "NotFound", Message: "not found"}}, entityErrors)
+}
+
+func TestFanOutAllFailed(t *testing.T) {
+	_, entityErrors, err := fanOut(context.Background(), []int64{1, 2}, 2, func(ctx context.Context, id int64) (int64, error) {
+		return 0, status.Error(codes.Unavailable, "unavailable")
+	})
+	assert.Error(t, err)
+	assert.Len(t, entityErrors, 2)
+}
+
+func TestFanOutConcurrencyLimit(t *testing.T) {
+	var inFlight, maxInFlight int64
+	ids := make([]int64, 20)
+	_, _, err := fanOut(context.Background(), ids, 3, func(ctx context.Context, id int64) (int64, error) {
+		current := atomic.AddInt64(&inFlight, 1)
+		for {
+			observed := atomic.LoadInt64(&maxInFlight)
+			if current <= observed || atomic.CompareAndSwapInt64(&maxInFlight, observed, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt64(&inFlight, -1)
+		return id, nil
+	})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, maxInFlight, int64(3))
+}
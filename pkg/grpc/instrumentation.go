@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// OtherAddress is the address label of upstream targets which are not known to the proxy.
+// Any address can be requested, using it as label would make the cardinality unbounded.
+const OtherAddress = "other"
+
+var (
+	upstreamRpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "channelz_proxy_upstream_rpc_duration_seconds",
+		Help:    "Duration of channelz rpcs sent to upstream targets",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"address", "method", "code"})
+	dialFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "channelz_proxy_dial_failures_total",
+		Help: "Number of failures to create an upstream connection",
+	}, []string{"address"})
+	// Rejected addresses are attacker controlled, they are not used as label
+	rejectedDestinations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "channelz_proxy_rejected_destinations_total",
+		Help: "Number of upstream destinations rejected by the allowlist",
+	})
+	limitedRpcs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "channelz_proxy_limited_rpcs_total",
+		Help: "Number of upstream rpcs rejected by the target limits",
+	}, []string{"address", "reason"})
+	connCacheSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "channelz_proxy_connection_cache_size",
+		Help: "Number of cached upstream connections",
+	})
+)
+
+// UpstreamStats accumulates the upstream rpcs sent on behalf of a request
+type UpstreamStats struct {
+	mu       sync.Mutex
+	rpcs     int
+	duration time.Duration
+}
+
+type upstreamStatsKey struct{}
+
+// WithUpstreamStats returns a context recording its upstream rpcs in stats
+func WithUpstreamStats(ctx context.Context, stats *UpstreamStats) context.Context {
+	return context.WithValue(ctx, upstreamStatsKey{}, stats)
+}
+
+func (s *UpstreamStats) add(duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rpcs++
+	s.duration += duration
+}
+
+// Get returns the number of upstream rpcs and their cumulated duration
+func (s *UpstreamStats) Get() (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rpcs, s.duration
+}
+
+// AddKnownAddresses registers a checker of the addresses used as metric labels
+func (c *ChannelzProxyServer) AddKnownAddresses(checker AddressChecker) {
+	c.knownAddressesMu.Lock()
+	defer c.knownAddressesMu.Unlock()
+	c.knownAddresses = append(c.knownAddresses, checker)
+}
+
+// addressLabel returns the address if it belongs to a known target or has a specific configuration,
+// OtherAddress otherwise
+func (c *ChannelzProxyServer) addressLabel(address string) string {
+	c.securityMu.RLock()
+	_, hasSecurity := c.targetSecurity[address]
+	c.securityMu.RUnlock()
+	c.limitsMu.Lock()
+	_, hasLimits := c.targetLimits[address]
+	c.limitsMu.Unlock()
+	if hasSecurity || hasLimits {
+		return address
+	}
+
+	c.knownAddressesMu.RLock()
+	defer c.knownAddressesMu.RUnlock()
+	for _, known := range c.knownAddresses {
+		if known(address) {
+			return address
+		}
+	}
+	return OtherAddress
+}
+
+// instrumentationInterceptor records the latency and status code of every upstream rpc
+func (c *ChannelzProxyServer) instrumentationInterceptor(address string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		duration := time.Since(start)
+		upstreamRpcDuration.WithLabelValues(c.addressLabel(address), method, status.Code(err).String()).Observe(duration.Seconds())
+		if stats, ok := ctx.Value(upstreamStatsKey{}).(*UpstreamStats); ok {
+			stats.add(duration)
+		}
+		return err
+	}
+}
@@ -0,0 +1,48 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAddressLabel(t *testing.T) {
+	c := NewChannelzProxyServer(zap.NewNop())
+	assert.Equal(t, OtherAddress, c.addressLabel("10.0.0.1:9000"))
+
+	c.AddKnownAddresses(func(address string) bool { return address == "10.0.0.1:9000" })
+	c.SetTargetSecurity("10.0.0.2:9000", InsecureSecurityConfig)
+	c.SetTargetLimits("10.0.0.3:9000", TargetLimits{Rate: 1})
+	assert.Equal(t, "10.0.0.1:9000", c.addressLabel("10.0.0.1:9000"))
+	assert.Equal(t, "10.0.0.2:9000", c.addressLabel("10.0.0.2:9000"))
+	assert.Equal(t, "10.0.0.3:9000", c.addressLabel("10.0.0.3:9000"))
+	assert.Equal(t, OtherAddress, c.addressLabel("attacker.example.com:1"))
+}
+
+func TestInstrumentationInterceptor(t *testing.T) {
+	c := NewChannelzProxyServer(zap.NewNop())
+	c.AddKnownAddresses(func(address string) bool { return address == "known:9000" })
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.NotFound, "not found")
+	}
+	method := "/grpc.channelz.v1.Channelz/TestInstrumentation"
+
+	series := testutil.CollectAndCount(upstreamRpcDuration)
+	stats := &UpstreamStats{}
+	ctx := WithUpstreamStats(context.Background(), stats)
+	for _, address := range []string{"known:9000", "unknown-1:9000", "unknown-2:9000"} {
+		err := c.instrumentationInterceptor(address)(ctx, method, nil, nil, nil, invoker)
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	}
+	rpcs, _ := stats.Get()
+	assert.Equal(t, 3, rpcs)
+
+	// Unknown addresses share a single series
+	assert.Equal(t, series+2, testutil.CollectAndCount(upstreamRpcDuration))
+}
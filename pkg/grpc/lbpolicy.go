@@ -0,0 +1,185 @@
+package grpc
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// LbPolicyEvicted is reported by the api when the trace dropped the events of the current LB policy
+const LbPolicyEvicted = "unknown (evicted)"
+
+// lbChainWindow groups the policy creations logged in a burst by grpc-core and grpc-java when a policy creates its children
+const lbChainWindow = time.Second
+
+// childPolicyKeys are the keys holding a child policy in the LB configs of grpc
+var childPolicyKeys = []string{"childPolicy", "endpointPickingPolicy", "xdsLbPolicy"}
+
+// LbPolicy is the LB policy of a channel detected from its trace events
+type LbPolicy struct {
+	// Policy is the top-level policy
+	Policy string
+	// Chain lists the nested policies, from the top-level policy to the leaf
+	Chain []string
+	// SwitchTime is the time of the last switch of the top-level policy
+	SwitchTime *timestamppb.Timestamp
+	// Evicted is set when the trace dropped the events of the current policy, Policy is then empty
+	Evicted bool
+}
+
+// String returns the chain of policies
+func (p LbPolicy) String() string {
+	if len(p.Chain) == 0 {
+		return p.Policy
+	}
+	return strings.Join(p.Chain, " → ")
+}
+
+// lbConfigChain follows the first policy of a loadBalancingConfig and its single children
+func lbConfigChain(configs []map[string]json.RawMessage) []string {
+	if len(configs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(configs[0]))
+	for name := range configs[0] {
+		names = append(names, name)
+	}
+	if len(names) != 1 {
+		return nil
+	}
+	chain := []string{names[0]}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(configs[0][names[0]], &config); err != nil {
+		return chain
+	}
+	for _, key := range childPolicyKeys {
+		var children []map[string]json.RawMessage
+		if err := json.Unmarshal(config[key], &children); err == nil && len(children) > 0 {
+			return append(chain, lbConfigChain(children)...)
+		}
+	}
+	// Policies with multiple children, like xds_cluster_manager, are not a chain
+	var children map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(config["children"], &children); err == nil && len(children) == 1 {
+		for _, child := range children {
+			var childPolicy []map[string]json.RawMessage
+			if err := json.Unmarshal(child["childPolicy"], &childPolicy); err == nil {
+				return append(chain, lbConfigChain(childPolicy)...)
+			}
+		}
+	}
+	return chain
+}
+
+// serviceConfigChain returns the LB policy chain of a service config, nil if it has no loadBalancingConfig
+func serviceConfigChain(serviceConfig string) []string {
+	var config struct {
+		LoadBalancingConfig []map[string]json.RawMessage `json:"loadBalancingConfig"`
+	}
+	if err := json.Unmarshal([]byte(serviceConfig), &config); err != nil {
+		return nil
+	}
+	return lbConfigChain(config.LoadBalancingConfig)
+}
+
+// logsNestedPolicies returns true if the implementation logs the creation of nested policies on the channel trace.
+// grpc-go only logs the top-level policy.
+func logsNestedPolicies(implementation string) bool {
+	return implementation == ImplementationCore || implementation == ImplementationJava
+}
+
+// lbPolicyBurst returns the end of the burst of nested policy creations starting at start
+func lbPolicyBurst(lbEvents []TraceEvent, start int) int {
+	end := start + 1
+	if !logsNestedPolicies(lbEvents[start].Implementation) {
+		return end
+	}
+	policies := []string{lbEvents[start].LbPolicy}
+	for ; end < len(lbEvents); end++ {
+		if lbEvents[end].Implementation != lbEvents[start].Implementation ||
+			lbEvents[end].Timestamp.Sub(lbEvents[end-1].Timestamp) > lbChainWindow ||
+			contains(policies, lbEvents[end].LbPolicy) {
+			break
+		}
+		policies = append(policies, lbEvents[end].LbPolicy)
+	}
+	return end
+}
+
+// index returns the position of value in values, -1 if absent
+func index[T comparable](values []T, value T) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// startsWithTopLevel returns true if the first retained policy creation of an evicted trace is the top-level policy.
+// A burst logged by grpc-core or grpc-java may only re-create a child, like round_robin under priority,
+// which can't be told without the top-level policy of the service config.
+func startsWithTopLevel(first TraceEvent, configChain []string) bool {
+	if len(configChain) > 0 {
+		return configChain[0] == first.LbPolicy
+	}
+	return !logsNestedPolicies(first.Implementation)
+}
+
+// detectLbPolicy finds the current LB policy from parsed trace events.
+// evicted is true when the trace dropped older events.
+func detectLbPolicy(events []TraceEvent, evicted bool) LbPolicy {
+	var lbEvents []TraceEvent
+	var serviceConfig string
+	for _, event := range events {
+		switch {
+		case event.Type == TraceEventLbPolicy:
+			lbEvents = append(lbEvents, event)
+		case event.ServiceConfig != "":
+			serviceConfig = event.ServiceConfig
+		}
+	}
+	if len(lbEvents) == 0 {
+		return LbPolicy{Evicted: evicted}
+	}
+	configChain := serviceConfigChain(serviceConfig)
+	if evicted && !startsWithTopLevel(lbEvents[0], configChain) {
+		return LbPolicy{Evicted: true}
+	}
+
+	var chain []string
+	var switchTime time.Time
+	for start := 0; start < len(lbEvents); {
+		end := lbPolicyBurst(lbEvents, start)
+		burst := make([]string, 0, end-start)
+		for _, event := range lbEvents[start:end] {
+			burst = append(burst, event.LbPolicy)
+		}
+		if i := index(chain, burst[0]); i > 0 {
+			// A child of the current chain was re-created, the top-level policy is unchanged
+			chain = append(chain[:i:i], burst...)
+		} else {
+			chain = burst
+			switchTime = lbEvents[start].Timestamp
+		}
+		start = end
+	}
+	if len(configChain) > len(chain) && configChain[0] == chain[0] {
+		chain = configChain
+	}
+	return LbPolicy{
+		Policy:     chain[0],
+		Chain:      chain,
+		SwitchTime: timestamppb.New(switchTime),
+	}
+}
+
+// DetectLbPolicy returns the LB policy of a channel found in its trace events
+func DetectLbPolicy(channel *channelzgrpc.Channel) LbPolicy {
+	trace := channel.GetData().GetTrace()
+	evicted := trace.GetNumEventsLogged() > int64(len(trace.GetEvents()))
+	return detectLbPolicy(ParseTraceEvents(trace.GetEvents()), evicted)
+}
@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func timedTraceEvent(description string, ts time.Time) *channelzgrpc.ChannelTraceEvent {
+	return &channelzgrpc.ChannelTraceEvent{Description: description, Timestamp: timestamppb.New(ts)}
+}
+
+func testChannel(numEventsLogged int64, events ...*channelzgrpc.ChannelTraceEvent) *channelzgrpc.Channel {
+	return &channelzgrpc.Channel{Data: &channelzgrpc.ChannelData{
+		Trace: &channelzgrpc.ChannelTrace{NumEventsLogged: numEventsLogged, Events: events},
+	}}
+}
+
+func TestDetectLbPolicyGo(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	channel := testChannel(3,
+		timedTraceEvent(`Channel switches to new LB policy "pick_first"`, start),
+		timedTraceEvent("Channel Connectivity change to READY", start.Add(time.Millisecond)),
+		timedTraceEvent(`Channel switches to new LB policy "round_robin"`, start.Add(time.Minute)),
+	)
+	lbPolicy := DetectLbPolicy(channel)
+	assert.Equal(t, "round_robin", lbPolicy.Policy)
+	assert.Equal(t, []string{"round_robin"}, lbPolicy.Chain)
+	assert.Equal(t, start.Add(time.Minute), lbPolicy.SwitchTime.AsTime())
+}
+
+func TestDetectLbPolicyJava(t *testing.T) {
+	channel := testChannel(1, timedTraceEvent("Load balancer changed from PickFirstLoadBalancer to RoundRobinLoadBalancer", time.Unix(1000, 0)))
+	assert.Equal(t, "round_robin", DetectLbPolicy(channel).Policy)
+}
+
+func TestDetectLbPolicyCoreChain(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	channel := testChannel(5,
+		timedTraceEvent(`Created new LB policy "pick_first"`, start),
+		timedTraceEvent(`Created new LB policy "cds_experimental"`, start.Add(time.Minute)),
+		timedTraceEvent("Resolution event: Service config changed", start.Add(time.Minute)),
+		timedTraceEvent(`Created new LB policy "xds_cluster_impl_experimental"`, start.Add(time.Minute+10*time.Millisecond)),
+		timedTraceEvent(`Created new LB policy "round_robin"`, start.Add(time.Minute+20*time.Millisecond)),
+	)
+	lbPolicy := DetectLbPolicy(channel)
+	assert.Equal(t, "cds_experimental", lbPolicy.Policy)
+	assert.Equal(t, []string{"cds_experimental", "xds_cluster_impl_experimental", "round_robin"}, lbPolicy.Chain)
+	assert.Equal(t, "cds_experimental → xds_cluster_impl_experimental → round_robin", lbPolicy.String())
+	assert.Equal(t, start.Add(time.Minute), lbPolicy.SwitchTime.AsTime())
+}
+
+func TestDetectLbPolicyServiceConfig(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	channel := testChannel(2,
+		timedTraceEvent(`ccResolverWrapper: got new service config: {"loadBalancingConfig":[{"cds_experimental":{"cluster":"foo","childPolicy":[{"xds_cluster_impl_experimental":{"childPolicy":[{"round_robin":{}}]}}]}}]}`, start),
+		timedTraceEvent(`Channel switches to new LB policy "cds_experimental"`, start),
+	)
+	assert.Equal(t, []string{"cds_experimental", "xds_cluster_impl_experimental", "round_robin"}, DetectLbPolicy(channel).Chain)
+}
+
+func TestDetectLbPolicyEvicted(t *testing.T) {
+	channel := testChannel(40, timedTraceEvent("Channel Connectivity change to READY", time.Unix(1000, 0)))
+	lbPolicy := DetectLbPolicy(channel)
+	assert.True(t, lbPolicy.Evicted)
+	assert.Equal(t, "", lbPolicy.Policy)
+	assert.Nil(t, lbPolicy.SwitchTime)
+	// The marker is only reported by the api
+	assert.Equal(t, LbPolicyEvicted, newChannelResult(channel).LbPolicy)
+
+	assert.Equal(t, LbPolicy{}, DetectLbPolicy(testChannel(1, timedTraceEvent("Channel created", time.Unix(1000, 0)))))
+}
+
+func TestDetectLbPolicyCoreChildRecreated(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	channel := testChannel(4,
+		timedTraceEvent(`Created new LB policy "cds_experimental"`, start),
+		timedTraceEvent(`Created new LB policy "xds_cluster_impl_experimental"`, start.Add(10*time.Millisecond)),
+		timedTraceEvent(`Created new LB policy "round_robin"`, start.Add(20*time.Millisecond)),
+		timedTraceEvent(`Created new LB policy "round_robin"`, start.Add(10*time.Minute)),
+	)
+	lbPolicy := DetectLbPolicy(channel)
+	assert.Equal(t, "cds_experimental", lbPolicy.Policy)
+	assert.Equal(t, []string{"cds_experimental", "xds_cluster_impl_experimental", "round_robin"}, lbPolicy.Chain)
+	assert.Equal(t, start, lbPolicy.SwitchTime.AsTime())
+
+	// A re-created intermediate policy replaces the end of the chain
+	channel.Data.Trace.Events = append(channel.Data.Trace.Events,
+		timedTraceEvent(`Created new LB policy "xds_cluster_impl_experimental"`, start.Add(20*time.Minute)),
+		timedTraceEvent(`Created new LB policy "pick_first"`, start.Add(20*time.Minute+time.Millisecond)),
+	)
+	channel.Data.Trace.NumEventsLogged = 6
+	lbPolicy = DetectLbPolicy(channel)
+	assert.Equal(t, []string{"cds_experimental", "xds_cluster_impl_experimental", "pick_first"}, lbPolicy.Chain)
+	assert.Equal(t, start, lbPolicy.SwitchTime.AsTime())
+}
+
+func TestDetectLbPolicyJavaChain(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	channel := testChannel(4,
+		timedTraceEvent("Load balancer changed from PickFirstLoadBalancer to CdsLoadBalancer2", start),
+		timedTraceEvent("Load balancer changed from PickFirstLoadBalancer to ClusterResolverLoadBalancer", start.Add(5*time.Millisecond)),
+		timedTraceEvent("Load balancer changed from PickFirstLoadBalancer to RoundRobinLoadBalancer", start.Add(10*time.Millisecond)),
+		timedTraceEvent("Entering READY state with picker: Picker{}", start.Add(time.Second)),
+	)
+	lbPolicy := DetectLbPolicy(channel)
+	assert.Equal(t, "cds", lbPolicy.Policy)
+	assert.Equal(t, []string{"cds", "cluster_resolver", "round_robin"}, lbPolicy.Chain)
+	assert.Equal(t, start, lbPolicy.SwitchTime.AsTime())
+}
+
+func TestDetectLbPolicyEvictedChildBurst(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	// The creation of priority was evicted, only its round_robin child re-creation is left
+	channel := testChannel(40,
+		timedTraceEvent(`Created new LB policy "round_robin"`, start),
+		timedTraceEvent("Channel Connectivity change to READY", start.Add(time.Second)),
+	)
+	lbPolicy := DetectLbPolicy(channel)
+	assert.Equal(t, LbPolicy{Evicted: true}, lbPolicy)
+	assert.Equal(t, LbPolicyEvicted, newChannelResult(channel).LbPolicy)
+
+	// The first retained switch is checked against the top-level policy of the service config
+	config := `ccResolverWrapper: got new service config: {"loadBalancingConfig":[{"priority_experimental":{"children":{"c":{"config":[{"round_robin":{}}]}}}}]}`
+	channel = testChannel(40,
+		timedTraceEvent(config, start),
+		timedTraceEvent(`Channel switches to new LB policy "round_robin"`, start.Add(time.Second)),
+	)
+	assert.Equal(t, LbPolicy{Evicted: true}, DetectLbPolicy(channel))
+	channel = testChannel(40,
+		timedTraceEvent(config, start),
+		timedTraceEvent(`Channel switches to new LB policy "priority_experimental"`, start.Add(time.Second)),
+	)
+	lbPolicy = DetectLbPolicy(channel)
+	assert.False(t, lbPolicy.Evicted)
+	assert.Equal(t, "priority_experimental", lbPolicy.Policy)
+
+	// grpc-go only logs the top-level policy
+	channel = testChannel(40, timedTraceEvent(`Channel switches to new LB policy "round_robin"`, start))
+	assert.Equal(t, "round_robin", DetectLbPolicy(channel).Policy)
+}
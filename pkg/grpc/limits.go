@@ -0,0 +1,244 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// TargetLimits protect an upstream target from the proxy.
+// Zero values disable the corresponding limit in the default limits,
+// and fall back to the default value in the limits of a specific target.
+// Negative values, like -1, disable a default limit for a specific target,
+// or the queue for MaxQueue and QueueTimeout.
+type TargetLimits struct {
+	// Rate is the number of rpcs per second sent to the target, with bursts of Burst rpcs
+	Rate  float64 `yaml:"rate" json:"rate,omitempty"`
+	Burst int     `yaml:"burst" json:"burst,omitempty"`
+	// MaxConcurrent is the number of rpcs in flight to the target.
+	// Up to MaxQueue rpcs wait at most QueueTimeout for a slot.
+	MaxConcurrent int           `yaml:"maxConcurrent" json:"maxConcurrent,omitempty"`
+	MaxQueue      int           `yaml:"maxQueue" json:"maxQueue,omitempty"`
+	QueueTimeout  time.Duration `yaml:"queueTimeout" json:"queueTimeout,omitempty"`
+}
+
+// MarshalJSON renders the queue timeout as a duration string, like the timeouts of targets
+func (l TargetLimits) MarshalJSON() ([]byte, error) {
+	type limits TargetLimits
+	res := struct {
+		limits
+		QueueTimeout string `json:"queueTimeout,omitempty"`
+	}{limits: limits(l)}
+	if l.QueueTimeout != 0 {
+		res.QueueTimeout = l.QueueTimeout.String()
+	}
+	return json.Marshal(res)
+}
+
+// RetryAfter returns the delay suggested by a ResourceExhausted error, 0 if there is none
+func RetryAfter(err error) time.Duration {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0
+	}
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok {
+			return retryInfo.GetRetryDelay().AsDuration()
+		}
+	}
+	return 0
+}
+
+func resourceExhausted(message string, retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, message)
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// withDefaults returns the limits with their zero fields set from the defaults, negative fields are kept unset
+func (l TargetLimits) withDefaults(defaults TargetLimits) TargetLimits {
+	if l.Rate == 0 {
+		l.Rate = defaults.Rate
+	}
+	if l.Burst == 0 {
+		l.Burst = defaults.Burst
+	}
+	if l.MaxConcurrent == 0 {
+		l.MaxConcurrent = defaults.MaxConcurrent
+	}
+	if l.MaxQueue == 0 {
+		l.MaxQueue = defaults.MaxQueue
+	}
+	if l.QueueTimeout == 0 {
+		l.QueueTimeout = defaults.QueueTimeout
+	}
+	return l
+}
+
+// targetLimiter enforces the limits of a target
+type targetLimiter struct {
+	limits  TargetLimits
+	limiter *rate.Limiter
+	// slots holds a token per rpc in flight
+	slots chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+}
+
+func newTargetLimiter(limits TargetLimits) *targetLimiter {
+	l := &targetLimiter{limits: limits}
+	if limits.Rate > 0 {
+		burst := limits.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		l.limiter = rate.NewLimiter(rate.Limit(limits.Rate), burst)
+	}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return l
+}
+
+// waitRate waits for a rate token if it is available within the queue timeout
+func (l *targetLimiter) waitRate(ctx context.Context, address string, label string) error {
+	if l.limiter == nil {
+		return nil
+	}
+	reservation := l.limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if delay > l.limits.QueueTimeout {
+		reservation.Cancel()
+		limitedRpcs.WithLabelValues(label, "rate").Inc()
+		return resourceExhausted("rate limit of "+address+" exceeded", delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		reservation.Cancel()
+		return status.FromContextError(ctx.Err()).Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// acquire takes an in-flight slot, queuing for at most the queue timeout
+func (l *targetLimiter) acquire(ctx context.Context, address string, label string) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= l.limits.MaxQueue {
+		l.mu.Unlock()
+		limitedRpcs.WithLabelValues(label, "queue_full").Inc()
+		return nil, resourceExhausted("too many rpcs queued for "+address, time.Second)
+	}
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.limits.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case <-timer.C:
+		limitedRpcs.WithLabelValues(label, "queue_timeout").Inc()
+		return nil, resourceExhausted("timeout waiting for a concurrency slot of "+address, l.limits.QueueTimeout)
+	}
+}
+
+// SetDefaultLimits sets the limits of targets without a specific configuration
+func (c *ChannelzProxyServer) SetDefaultLimits(limits TargetLimits) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	c.defaultLimits = limits
+	c.limiters = make(map[string]*targetLimiter)
+}
+
+// SetTargetLimits sets the limits of a specific address, its zero fields use the default limits
+func (c *ChannelzProxyServer) SetTargetLimits(address string, limits TargetLimits) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	c.targetLimits[address] = limits
+	delete(c.limiters, address)
+}
+
+// TargetLimits returns the limits set for a specific address
+func (c *ChannelzProxyServer) TargetLimits(address string) (TargetLimits, bool) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	limits, ok := c.targetLimits[address]
+	return limits, ok
+}
+
+// RemoveTargetLimits makes the address use the default limits again
+func (c *ChannelzProxyServer) RemoveTargetLimits(address string) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	delete(c.targetLimits, address)
+	delete(c.limiters, address)
+}
+
+// removeLimiter drops the limiter of an address once no connection to it is cached
+func (c *ChannelzProxyServer) removeLimiter(address string) {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	delete(c.limiters, address)
+}
+
+func (c *ChannelzProxyServer) limiterFor(address string) *targetLimiter {
+	c.limitsMu.Lock()
+	defer c.limitsMu.Unlock()
+	limiter, ok := c.limiters[address]
+	if !ok {
+		limiter = newTargetLimiter(c.targetLimits[address].withDefaults(c.defaultLimits))
+		c.limiters[address] = limiter
+	}
+	return limiter
+}
+
+// limitsInterceptor applies the rate limit and the concurrency bulkhead of the address to every rpc
+func (c *ChannelzProxyServer) limitsInterceptor(address string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		limiter := c.limiterFor(address)
+		label := c.addressLabel(address)
+		if err := limiter.waitRate(ctx, address, label); err != nil {
+			return err
+		}
+		release, err := limiter.acquire(ctx, address, label)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
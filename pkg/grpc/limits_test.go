@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTargetLimiterRate(t *testing.T) {
+	limiter := newTargetLimiter(TargetLimits{Rate: 1, Burst: 2})
+	ctx := context.Background()
+	require.NoError(t, limiter.waitRate(ctx, "test", "test"))
+	require.NoError(t, limiter.waitRate(ctx, "test", "test"))
+
+	err := limiter.waitRate(ctx, "test", "test")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Greater(t, RetryAfter(err), time.Duration(0))
+	assert.LessOrEqual(t, RetryAfter(err), time.Second)
+}
+
+func TestTargetLimiterConcurrency(t *testing.T) {
+	limiter := newTargetLimiter(TargetLimits{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+	release, err := limiter.acquire(ctx, "test", "test")
+	require.NoError(t, err)
+
+	// The queued rpc gets the slot once released
+	acquired := make(chan error)
+	go func() {
+		queuedRelease, err := limiter.acquire(ctx, "test", "test")
+		if err == nil {
+			queuedRelease()
+		}
+		acquired <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// The queue is full
+	_, err = limiter.acquire(ctx, "test", "test")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	release()
+	assert.NoError(t, <-acquired)
+
+	// A queued rpc times out if the slot is not released
+	release, err = limiter.acquire(ctx, "test", "test")
+	require.NoError(t, err)
+	defer release()
+	_, err = limiter.acquire(ctx, "test", "test")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 50*time.Millisecond, RetryAfter(err))
+}
+
+func TestRetryAfterOtherErrors(t *testing.T) {
+	assert.Equal(t, time.Duration(0), RetryAfter(status.Error(codes.Unavailable, "unavailable")))
+	assert.Equal(t, time.Duration(0), RetryAfter(nil))
+}
+
+func TestLimiterEvictedWithConnection(t *testing.T) {
+	c := NewChannelzProxyServer(zap.NewNop())
+	c.SetConnectionCacheLimits(1, time.Hour)
+	defer c.connCache.closeAll()
+
+	getTestConn(t, c.connCache, "localhost:1")
+	first := c.limiterFor("localhost:1")
+	assert.Same(t, first, c.limiterFor("localhost:1"))
+
+	// Evicting the only connection to the address drops its limiter
+	getTestConn(t, c.connCache, "localhost:2")
+	c.limitsMu.Lock()
+	assert.NotContains(t, c.limiters, "localhost:1")
+	c.limitsMu.Unlock()
+	assert.NotSame(t, first, c.limiterFor("localhost:1"))
+}
+
+func TestTargetLimitsWithDefaults(t *testing.T) {
+	defaults := TargetLimits{Rate: 10, Burst: 50, MaxConcurrent: 4, MaxQueue: 100, QueueTimeout: 2 * time.Second}
+	assert.Equal(t, TargetLimits{Rate: 5, Burst: 50, MaxConcurrent: 4, MaxQueue: 100, QueueTimeout: 2 * time.Second},
+		TargetLimits{Rate: 5}.withDefaults(defaults))
+	assert.Equal(t, defaults, TargetLimits{}.withDefaults(defaults))
+
+	c := NewChannelzProxyServer(zap.NewNop())
+	c.SetDefaultLimits(defaults)
+	c.SetTargetLimits("localhost:1", TargetLimits{Rate: 5})
+	limiter := c.limiterFor("localhost:1")
+	assert.Equal(t, 4, cap(limiter.slots))
+	assert.Equal(t, 2*time.Second, limiter.limits.QueueTimeout)
+}
+
+func TestTargetLimitsDisableDefaults(t *testing.T) {
+	defaults := TargetLimits{Rate: 10, Burst: 50, MaxConcurrent: 4, MaxQueue: 100, QueueTimeout: 2 * time.Second}
+	c := NewChannelzProxyServer(zap.NewNop())
+	c.SetDefaultLimits(defaults)
+	c.SetTargetLimits("localhost:1", TargetLimits{Rate: -1, MaxConcurrent: -1})
+	limiter := c.limiterFor("localhost:1")
+	assert.Nil(t, limiter.limiter)
+	assert.Nil(t, limiter.slots)
+
+	// Without a queue, rpcs are rejected as soon as the slots are taken
+	c.SetTargetLimits("localhost:2", TargetLimits{MaxConcurrent: 1, MaxQueue: -1})
+	limiter = c.limiterFor("localhost:2")
+	release, err := limiter.acquire(context.Background(), "localhost:2", "localhost:2")
+	require.NoError(t, err)
+	defer release()
+	_, err = limiter.acquire(context.Background(), "localhost:2", "localhost:2")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,55 @@
+package grpc
+
+// Page is the position reached by a paginated listing
+type Page struct {
+	// NextStartId is the id to use as start id to get the next results
+	NextStartId int64 `json:"nextStartId"`
+	// End is true when all entities were returned
+	End bool `json:"end"`
+}
+
+// pageFetcher requests a single upstream page starting at startId with at most maxResults entities.
+// A maxResults of 0 lets the upstream server pick the page size.
+type pageFetcher[T any] func(startId int64, maxResults int64) ([]T, bool, error)
+
+// paginate follows upstream pages until the end is reached or limit entities were collected.
+// A limit of 0 collects all entities. pageSize is the maximum number of entities requested per page.
+func paginate[T any](startId int64, limit int64, pageSize int64, fetch pageFetcher[T], getId func(T) int64) ([]T, Page, error) {
+	res := make([]T, 0)
+	currentStart := startId
+	for {
+		maxResults := pageSize
+		if limit > 0 {
+			remaining := limit - int64(len(res))
+			if maxResults == 0 || remaining < maxResults {
+				maxResults = remaining
+			}
+		}
+		items, end, err := fetch(currentStart, maxResults)
+		if err != nil {
+			return nil, Page{}, err
+		}
+		for i, item := range items {
+			if limit > 0 && int64(len(res)) >= limit {
+				// Upstream ignored max results, truncate and resume after the last returned entity
+				return res, Page{NextStartId: getId(items[i-1]) + 1}, nil
+			}
+			res = append(res, item)
+		}
+		if end {
+			return res, Page{End: true}, nil
+		}
+		if len(items) == 0 {
+			// Nothing returned without reaching the end, let the caller retry from the same point
+			return res, Page{NextStartId: currentStart}, nil
+		}
+		nextStart := getId(items[len(items)-1]) + 1
+		if nextStart <= currentStart {
+			return res, Page{NextStartId: currentStart}, nil
+		}
+		currentStart = nextStart
+		if limit > 0 && int64(len(res)) >= limit {
+			return res, Page{NextStartId: currentStart}, nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePages serves ids in pages of pageSize, ignoring maxResults when ignoreMax is set
+func fakePages(ids []int64, pageSize int, ignoreMax bool) pageFetcher[int64] {
+	return func(startId int64, maxResults int64) ([]int64, bool, error) {
+		size := pageSize
+		if !ignoreMax && maxResults > 0 && int(maxResults) < size {
+			size = int(maxResults)
+		}
+		res := make([]int64, 0)
+		i := 0
+		for ; i < len(ids) && len(res) < size; i++ {
+			if ids[i] >= startId {
+				res = append(res, ids[i])
+			}
+		}
+		return res, i == len(ids), nil
+	}
+}
+
+func identity(id int64) int64 { return id }
+
+func TestPaginateFollowsPages(t *testing.T) {
+	ids := []int64{1, 2, 5, 8, 13}
+	res, page, err := paginate(0, 0, 0, fakePages(ids, 2, false), identity)
+	require.NoError(t, err)
+	assert.Equal(t, ids, res)
+	assert.True(t, page.End)
+}
+
+func TestPaginateLimit(t *testing.T) {
+	ids := []int64{1, 2, 5, 8, 13}
+	res, page, err := paginate(0, 3, 0, fakePages(ids, 2, false), identity)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 5}, res)
+	assert.Equal(t, Page{NextStartId: 6}, page)
+
+	res, page, err = paginate(page.NextStartId, 3, 0, fakePages(ids, 2, false), identity)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{8, 13}, res)
+	assert.True(t, page.End)
+}
+
+func TestPaginateUpstreamIgnoresMaxResults(t *testing.T) {
+	ids := []int64{1, 2, 5, 8, 13}
+	res, page, err := paginate(0, 2, 0, fakePages(ids, 10, true), identity)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, res)
+	assert.Equal(t, Page{NextStartId: 3}, page)
+}
@@ -0,0 +1,231 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"gopkg.in/yaml.v3"
+)
+
+// SecurityConfig describes the transport security used to reach a channelz endpoint
+type SecurityConfig struct {
+	// Insecure uses a plaintext connection, all other fields are ignored
+	Insecure bool `yaml:"insecure" json:"insecure"`
+	// CAFile is a PEM bundle used to verify the server certificate.
+	// System roots are used when empty
+	CAFile string `yaml:"caFile" json:"caFile,omitempty"`
+	// CertFile and KeyFile are the client certificate and key used for mTLS
+	CertFile string `yaml:"certFile" json:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile" json:"keyFile,omitempty"`
+	// ServerName overrides the name used for SNI and certificate verification
+	ServerName string `yaml:"serverName" json:"serverName,omitempty"`
+	// Credentials are sent with every call, they require TLS
+	Credentials *CallCredentials `yaml:"credentials" json:"credentials,omitempty"`
+}
+
+// InsecureSecurityConfig is the plaintext configuration
+var InsecureSecurityConfig = SecurityConfig{Insecure: true}
+
+func (s SecurityConfig) cacheKey() string {
+	if s.Insecure {
+		return "insecure"
+	}
+	return fmt.Sprintf("tls|ca=%s|cert=%s|key=%s|sn=%s", s.CAFile, s.CertFile, s.KeyFile, s.ServerName) + s.Credentials.cacheKey()
+}
+
+func (s SecurityConfig) validate() error {
+	if s.Insecure {
+		if s.Credentials != nil {
+			return errors.New("credentials require TLS")
+		}
+		return nil
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return errors.New("certFile and keyFile should be set together")
+	}
+	if s.Credentials != nil {
+		return s.Credentials.validate()
+	}
+	return nil
+}
+
+// Validate checks the consistency of the configuration
+func (s SecurityConfig) Validate() error {
+	return s.validate()
+}
+
+// verifyName returns the name the certificate of address is verified against.
+// The TLS client sends no SNI for IP addresses, so the dialed host is used rather than the negotiated server name.
+func (s SecurityConfig) verifyName(address string) string {
+	if s.ServerName != "" {
+		return s.ServerName
+	}
+	// Strip the scheme of grpc targets like dns:///host:port
+	if _, endpoint, found := strings.Cut(address, ":///"); found {
+		address = endpoint
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+func (s SecurityConfig) transportCredentials(address string) (credentials.TransportCredentials, error) {
+	if s.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	reloader := &certReloader{config: s}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName: s.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if s.CertFile != "" {
+		tlsConfig.GetClientCertificate = reloader.getClientCertificate
+	}
+	if s.CAFile != "" {
+		// Verification is done in VerifyConnection against the reloadable pool
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = reloader.verifyConnection(s.verifyName(address))
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// LoadTargetSecurityFile reads a yaml file mapping addresses to their security configuration
+func LoadTargetSecurityFile(path string) (map[string]SecurityConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read target security file")
+	}
+	res := make(map[string]SecurityConfig)
+	if err := yaml.Unmarshal(content, &res); err != nil {
+		return nil, errors.Wrap(err, "failed to parse target security file")
+	}
+	for address, config := range res {
+		if err := config.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid security for %s", address)
+		}
+	}
+	return res, nil
+}
+
+// certReloader keeps the client certificate and CA pool in sync with the files on disk.
+// Files are checked on every handshake and reloaded when their modification time changes.
+type certReloader struct {
+	config SecurityConfig
+
+	mu        sync.Mutex
+	modTimes  map[string]time.Time
+	clientCrt *tls.Certificate
+	caPool    *x509.CertPool
+}
+
+func (r *certReloader) filesChanged() bool {
+	for _, path := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if path == "" {
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			// Keep the current material while a rotation is in progress
+			return false
+		}
+		if !stat.ModTime().Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if path == "" {
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", path)
+		}
+		modTimes[path] = stat.ModTime()
+	}
+
+	var caPool *x509.CertPool
+	if r.config.CAFile != "" {
+		pem, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read CA file")
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificate found in %s", r.config.CAFile)
+		}
+	}
+	var clientCrt *tls.Certificate
+	if r.config.CertFile != "" {
+		crt, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to load client certificate")
+		}
+		clientCrt = &crt
+	}
+
+	r.modTimes = modTimes
+	r.caPool = caPool
+	r.clientCrt = clientCrt
+	return nil
+}
+
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.filesChanged() {
+		// On failure, keep using the previous material
+		_ = r.reload()
+	}
+	return r.clientCrt, r.caPool
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	crt, _ := r.current()
+	return crt, nil
+}
+
+// verifyConnection verifies the peer certificate against the current CA pool and the expected name,
+// a hostname or an IP address
+func (r *certReloader) verifyConnection(name string) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		_, caPool := r.current()
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no peer certificate")
+		}
+		if name == "" {
+			return errors.New("no name to verify the peer certificate against")
+		}
+		intermediates := x509.NewCertPool()
+		for _, crt := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(crt)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       name,
+			Roots:         caPool,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+}
@@ -0,0 +1,175 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	crt *x509.Certificate
+	key *ecdsa.PrivateKey
+	der []byte
+}
+
+func createTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parentCrt, parentKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parentCrt, parentKey = parent.crt, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCrt, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	crt, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{crt: crt, key: key, der: der}
+}
+
+// createServerCert creates a server certificate signed by ca for the given DNS names and IP addresses
+func createServerCert(t *testing.T, ca *testCert, dnsNames []string, ips []net.IP) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(10),
+		Subject:      pkix.Name{CommonName: "server"},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.crt, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsHandshake runs a client handshake of the credentials built for the address of a TLS listener
+func tlsHandshake(t *testing.T, security SecurityConfig, serverCert tls.Certificate) error {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	address := listener.Addr().String()
+	creds, err := security.transportCredentials(address)
+	require.NoError(t, err)
+	rawConn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer rawConn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _, err = creds.ClientHandshake(ctx, address, rawConn)
+	return err
+}
+
+func (c *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	crtPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(crtPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return crtPath, keyPath
+}
+
+func TestSecurityCacheKey(t *testing.T) {
+	tlsConfig := SecurityConfig{CAFile: "ca.crt"}
+	assert.NotEqual(t, InsecureSecurityConfig.cacheKey(), tlsConfig.cacheKey())
+	assert.NotEqual(t, tlsConfig.cacheKey(), SecurityConfig{CAFile: "ca.crt", ServerName: "foo"}.cacheKey())
+	assert.Equal(t, InsecureSecurityConfig.cacheKey(), SecurityConfig{Insecure: true, CAFile: "ca.crt"}.cacheKey())
+}
+
+func TestSecurityValidate(t *testing.T) {
+	_, err := SecurityConfig{CertFile: "client.crt"}.transportCredentials("localhost:8080")
+	assert.Error(t, err)
+	assert.Error(t, SecurityConfig{Insecure: true, Credentials: &CallCredentials{BearerToken: "token"}}.Validate())
+}
+
+func TestTLSHandshakeIPAddress(t *testing.T) {
+	dir := t.TempDir()
+	ca := createTestCert(t, "ca", 1, nil)
+	caPath, _ := ca.write(t, dir, "ca")
+	security := SecurityConfig{CAFile: caPath}
+
+	// A certificate signed by the CA for another name is rejected when dialing an IP address
+	err := tlsHandshake(t, security, createServerCert(t, ca, []string{"other.svc"}, nil))
+	assert.Error(t, err)
+	err = tlsHandshake(t, security, createServerCert(t, ca, nil, []net.IP{net.ParseIP("10.0.0.12")}))
+	assert.Error(t, err)
+
+	err = tlsHandshake(t, security, createServerCert(t, ca, nil, []net.IP{net.ParseIP("127.0.0.1")}))
+	assert.NoError(t, err)
+
+	// The server name override is verified instead of the dialed host
+	err = tlsHandshake(t, SecurityConfig{CAFile: caPath, ServerName: "other.svc"}, createServerCert(t, ca, []string{"other.svc"}, nil))
+	assert.NoError(t, err)
+}
+
+func TestCallCredentials(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("secret\n"), 0600))
+	creds := &CallCredentials{BearerTokenFile: tokenPath, Headers: map[string]string{"X-Tenant": "a"}}
+	metadata, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer secret", "x-tenant": "a"}, metadata)
+
+	withCreds := SecurityConfig{CAFile: "ca.crt", Credentials: creds}
+	assert.NotEqual(t, SecurityConfig{CAFile: "ca.crt"}.cacheKey(), withCreds.cacheKey())
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := createTestCert(t, "ca", 1, nil)
+	caPath, _ := ca.write(t, dir, "ca")
+	client := createTestCert(t, "client", 2, ca)
+	crtPath, keyPath := client.write(t, dir, "client")
+
+	reloader := &certReloader{config: SecurityConfig{CAFile: caPath, CertFile: crtPath, KeyFile: keyPath}}
+	require.NoError(t, reloader.reload())
+	crt, _ := reloader.current()
+	assert.Equal(t, client.der, crt.Certificate[0])
+
+	rotated := createTestCert(t, "client", 3, ca)
+	rotated.write(t, dir, "client")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(crtPath, future, future))
+	require.NoError(t, os.Chtimes(keyPath, future, future))
+
+	crt, _ = reloader.current()
+	assert.Equal(t, rotated.der, crt.Certificate[0])
+}
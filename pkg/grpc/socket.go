@@ -0,0 +1,180 @@
+package grpc
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// SocketResult is a socket rendered with its decoded representation.
+// Decoding happens when the socket is marshaled.
+type SocketResult struct {
+	*channelzgrpc.Socket
+}
+
+// MarshalJSON adds the decoded representation to the socket
+func (s SocketResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*channelzgrpc.Socket
+		Decoded DecodedSocket `json:"decoded"`
+	}{s.Socket, s.Decode()})
+}
+
+// DecodedSocket is the readable representation of the addresses, security and options of a socket
+type DecodedSocket struct {
+	Local    string           `json:"local,omitempty"`
+	Remote   string           `json:"remote,omitempty"`
+	Security *DecodedSecurity `json:"security,omitempty"`
+	Options  []DecodedOption  `json:"options,omitempty"`
+}
+
+// DecodedSecurity describes the TLS parameters or the other security of a socket
+type DecodedSecurity struct {
+	// Kind is tls or other
+	Kind              string       `json:"kind"`
+	StandardName      string       `json:"standard_name,omitempty"`
+	OtherName         string       `json:"other_name,omitempty"`
+	LocalCertificate  *Certificate `json:"local_certificate,omitempty"`
+	RemoteCertificate *Certificate `json:"remote_certificate,omitempty"`
+	// Name and TypeUrl describe an OtherSecurity
+	Name    string `json:"name,omitempty"`
+	TypeUrl string `json:"type_url,omitempty"`
+}
+
+// Certificate is a parsed DER certificate
+type Certificate struct {
+	Subject string `json:"subject,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	Serial  string `json:"serial,omitempty"`
+	// SANs are the subject alternative names prefixed by their type, like DNS:example.com or IP:10.0.0.1
+	SANs      []string  `json:"sans,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// Fingerprint is the hex encoded sha256 of the DER certificate
+	Fingerprint string `json:"fingerprint"`
+	// Error is set when the certificate could not be parsed
+	Error string `json:"error,omitempty"`
+}
+
+// DecodedOption is a socket option with its additional value unpacked when its type is known
+type DecodedOption struct {
+	Name    string                            `json:"name"`
+	Value   string                            `json:"value,omitempty"`
+	Timeout string                            `json:"timeout,omitempty"`
+	Linger  *DecodedLinger                    `json:"linger,omitempty"`
+	TcpInfo *channelzgrpc.SocketOptionTcpInfo `json:"tcp_info,omitempty"`
+	// TypeUrl is the type of an additional value which is not unpacked
+	TypeUrl string `json:"type_url,omitempty"`
+}
+
+// DecodedLinger is an unpacked SocketOptionLinger
+type DecodedLinger struct {
+	Active   bool   `json:"active"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// ParseCertificate parses a DER certificate, the error is reported in the result
+func ParseCertificate(der []byte) *Certificate {
+	if len(der) == 0 {
+		return nil
+	}
+	fingerprint := sha256.Sum256(der)
+	res := &Certificate{Fingerprint: hex.EncodeToString(fingerprint[:])}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Subject = cert.Subject.String()
+	res.Issuer = cert.Issuer.String()
+	res.Serial = cert.SerialNumber.Text(16)
+	res.NotBefore = cert.NotBefore
+	res.NotAfter = cert.NotAfter
+	for _, name := range cert.DNSNames {
+		res.SANs = append(res.SANs, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		res.SANs = append(res.SANs, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		res.SANs = append(res.SANs, "URI:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		res.SANs = append(res.SANs, "email:"+email)
+	}
+	return res
+}
+
+func decodeSecurity(security *channelzgrpc.Security) *DecodedSecurity {
+	if tls := security.GetTls(); tls != nil {
+		return &DecodedSecurity{
+			Kind:              "tls",
+			StandardName:      tls.GetStandardName(),
+			OtherName:         tls.GetOtherName(),
+			LocalCertificate:  ParseCertificate(tls.GetLocalCertificate()),
+			RemoteCertificate: ParseCertificate(tls.GetRemoteCertificate()),
+		}
+	}
+	if other := security.GetOther(); other != nil {
+		return &DecodedSecurity{
+			Kind:    "other",
+			Name:    other.GetName(),
+			TypeUrl: other.GetValue().GetTypeUrl(),
+		}
+	}
+	return nil
+}
+
+func decodeOption(option *channelzgrpc.SocketOption) DecodedOption {
+	res := DecodedOption{Name: option.GetName(), Value: option.GetValue()}
+	additional := option.GetAdditional()
+	if additional == nil {
+		return res
+	}
+	message, err := additional.UnmarshalNew()
+	if err != nil {
+		res.TypeUrl = additional.GetTypeUrl()
+		return res
+	}
+	switch value := message.(type) {
+	case *channelzgrpc.SocketOptionTimeout:
+		res.Timeout = value.GetDuration().AsDuration().String()
+	case *channelzgrpc.SocketOptionLinger:
+		res.Linger = &DecodedLinger{Active: value.GetActive()}
+		if value.GetDuration() != nil {
+			res.Linger.Duration = value.GetDuration().AsDuration().String()
+		}
+	case *channelzgrpc.SocketOptionTcpInfo:
+		res.TcpInfo = value
+	default:
+		res.TypeUrl = additional.GetTypeUrl()
+	}
+	return res
+}
+
+// Decode decodes the addresses, security and options of the socket
+func (s SocketResult) Decode() DecodedSocket {
+	socket := s.Socket
+	res := DecodedSocket{
+		Local:    FormatAddress(socket.GetLocal()),
+		Remote:   FormatAddress(socket.GetRemote()),
+		Security: decodeSecurity(socket.GetSecurity()),
+	}
+	for _, option := range socket.GetData().GetOption() {
+		res.Options = append(res.Options, decodeOption(option))
+	}
+	return res
+}
+
+// NewSocketResults wraps a list of sockets
+func NewSocketResults(sockets []*channelzgrpc.Socket) []SocketResult {
+	res := make([]SocketResult, 0, len(sockets))
+	for _, socket := range sockets {
+		res = append(res, SocketResult{Socket: socket})
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func testCertificate(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xcafe),
+		Subject:      pkix.Name{CommonName: "api.svc"},
+		DNSNames:     []string{"api.svc"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
+
+func testOption(t *testing.T, name string, message proto.Message) *channelzgrpc.SocketOption {
+	additional, err := anypb.New(message)
+	require.NoError(t, err)
+	return &channelzgrpc.SocketOption{Name: name, Additional: additional}
+}
+
+func TestParseCertificate(t *testing.T) {
+	notAfter := time.Unix(2000000000, 0).UTC()
+	cert := ParseCertificate(testCertificate(t, notAfter))
+	require.NotNil(t, cert)
+	assert.Equal(t, "CN=api.svc", cert.Subject)
+	assert.Equal(t, "CN=api.svc", cert.Issuer)
+	assert.Equal(t, "cafe", cert.Serial)
+	assert.Equal(t, []string{"DNS:api.svc", "IP:10.0.0.1"}, cert.SANs)
+	assert.Equal(t, notAfter, cert.NotAfter.UTC())
+	assert.Len(t, cert.Fingerprint, 64)
+	assert.Empty(t, cert.Error)
+
+	assert.Nil(t, ParseCertificate(nil))
+	invalid := ParseCertificate([]byte("invalid"))
+	assert.NotEmpty(t, invalid.Error)
+	assert.NotEmpty(t, invalid.Fingerprint)
+}
+
+func TestDecodeSocket(t *testing.T) {
+	der := testCertificate(t, time.Unix(2000000000, 0))
+	socket := SocketResult{Socket: &channelzgrpc.Socket{
+		Local: &channelzgrpc.Address{Address: &channelzgrpc.Address_TcpipAddress{
+			TcpipAddress: &channelzgrpc.Address_TcpIpAddress{IpAddress: net.ParseIP("::1"), Port: 443}}},
+		Remote: &channelzgrpc.Address{Address: &channelzgrpc.Address_UdsAddress_{
+			UdsAddress: &channelzgrpc.Address_UdsAddress{Filename: "/tmp/grpc.sock"}}},
+		Security: &channelzgrpc.Security{Model: &channelzgrpc.Security_Tls_{Tls: &channelzgrpc.Security_Tls{
+			CipherSuite:       &channelzgrpc.Security_Tls_StandardName{StandardName: "TLS_AES_128_GCM_SHA256"},
+			RemoteCertificate: der,
+		}}},
+		Data: &channelzgrpc.SocketData{Option: []*channelzgrpc.SocketOption{
+			{Name: "SO_KEEPALIVE", Value: "true"},
+			testOption(t, "SO_RCVTIMEO", &channelzgrpc.SocketOptionTimeout{Duration: durationpb.New(5 * time.Second)}),
+			testOption(t, "SO_LINGER", &channelzgrpc.SocketOptionLinger{Active: true, Duration: durationpb.New(time.Second)}),
+			testOption(t, "TCP_INFO", &channelzgrpc.SocketOptionTcpInfo{TcpiRtt: 120}),
+			testOption(t, "CUSTOM", durationpb.New(time.Second)),
+		}},
+	}}
+
+	decoded := socket.Decode()
+	assert.Equal(t, "[::1]:443", decoded.Local)
+	assert.Equal(t, "unix:/tmp/grpc.sock", decoded.Remote)
+	require.NotNil(t, decoded.Security)
+	assert.Equal(t, "tls", decoded.Security.Kind)
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", decoded.Security.StandardName)
+	assert.Nil(t, decoded.Security.LocalCertificate)
+	require.NotNil(t, decoded.Security.RemoteCertificate)
+	assert.Equal(t, "CN=api.svc", decoded.Security.RemoteCertificate.Subject)
+
+	require.Len(t, decoded.Options, 5)
+	assert.Equal(t, DecodedOption{Name: "SO_KEEPALIVE", Value: "true"}, decoded.Options[0])
+	assert.Equal(t, "5s", decoded.Options[1].Timeout)
+	assert.Equal(t, &DecodedLinger{Active: true, Duration: "1s"}, decoded.Options[2].Linger)
+	assert.Equal(t, uint32(120), decoded.Options[3].TcpInfo.GetTcpiRtt())
+	assert.Equal(t, "type.googleapis.com/google.protobuf.Duration", decoded.Options[4].TypeUrl)
+
+	content, err := json.Marshal(socket)
+	require.NoError(t, err)
+	var res map[string]interface{}
+	require.NoError(t, json.Unmarshal(content, &res))
+	assert.Contains(t, res, "decoded")
+	assert.Contains(t, res, "security")
+}
+
+func TestDecodeOtherSecurity(t *testing.T) {
+	security := decodeSecurity(&channelzgrpc.Security{Model: &channelzgrpc.Security_Other{
+		Other: &channelzgrpc.Security_OtherSecurity{Name: "alts", Value: &anypb.Any{TypeUrl: "type.googleapis.com/grpc.gcp.AltsContext"}}}})
+	assert.Equal(t, &DecodedSecurity{Kind: "other", Name: "alts", TypeUrl: "type.googleapis.com/grpc.gcp.AltsContext"}, security)
+	assert.Nil(t, decodeSecurity(nil))
+}
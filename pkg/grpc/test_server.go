@@ -3,6 +3,7 @@ package grpc
 import (
 	"context"
 	"net"
+	"testing"
 	"time"
 
 	"github.com/pkg/errors"
@@ -63,3 +64,22 @@ func StartTestServer(ctx context.Context, logger *zap.Logger, listenAddress stri
 	}
 
 }
+
+// ServeTestServer starts a grpc server on a random local port with the services added by register,
+// stops it at the end of the test and returns its address. A nil register serves no service.
+func ServeTestServer(t testing.TB, register func(s grpc.ServiceRegistrar)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := grpc.NewServer()
+	if register != nil {
+		register(s)
+	}
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+	return listener.Addr().String()
+}
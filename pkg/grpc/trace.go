@@ -0,0 +1,269 @@
+package grpc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+type TraceEventType string
+
+const (
+	TraceEventConnectivityState TraceEventType = "connectivity_state"
+	TraceEventResolverUpdate    TraceEventType = "resolver_update"
+	TraceEventLbPolicy          TraceEventType = "lb_policy"
+	TraceEventSubchannelCreated TraceEventType = "subchannel_created"
+	TraceEventSubchannelDeleted TraceEventType = "subchannel_deleted"
+	TraceEventServiceConfig     TraceEventType = "service_config"
+	TraceEventUnknown           TraceEventType = "unknown"
+)
+
+var traceEventTypes = []TraceEventType{
+	TraceEventConnectivityState,
+	TraceEventResolverUpdate,
+	TraceEventLbPolicy,
+	TraceEventSubchannelCreated,
+	TraceEventSubchannelDeleted,
+	TraceEventServiceConfig,
+	TraceEventUnknown,
+}
+
+// Implementations of grpc with their own trace wording
+const (
+	ImplementationGo   = "grpc-go"
+	ImplementationJava = "grpc-java"
+	// ImplementationCore is the C core shared by C++, Python and Ruby
+	ImplementationCore = "grpc-core"
+)
+
+// TraceEvent is a channel trace event with its description parsed
+type TraceEvent struct {
+	Type           TraceEventType `json:"type"`
+	Implementation string         `json:"implementation,omitempty"`
+	Severity       string         `json:"severity"`
+	Timestamp      time.Time      `json:"timestamp"`
+	Description    string         `json:"description"`
+	// State is the new connectivity state of a connectivity_state event
+	State string `json:"state,omitempty"`
+	// Addresses are the resolved addresses of a resolver_update event, when the implementation logs them
+	Addresses []string `json:"addresses,omitempty"`
+	// LbPolicy is the new policy of a lb_policy event
+	LbPolicy string `json:"lb_policy,omitempty"`
+	// SubchannelId is the subchannel created or deleted
+	SubchannelId int64 `json:"subchannel_id,omitempty"`
+	// ServiceConfig is the new service config, when the implementation logs it
+	ServiceConfig string `json:"service_config,omitempty"`
+}
+
+// traceWording matches the description of a trace event from one implementation.
+// Named groups state, addresses, policy, id and config fill the typed fields.
+type traceWording struct {
+	implementation string
+	eventType      TraceEventType
+	pattern        *regexp.Regexp
+}
+
+var (
+	goAddressPattern = regexp.MustCompile(`"?Addr"?:\s*"?([^\s",}]+)`)
+	// Java prints InetSocketAddress as hostname/ip:port
+	javaAddressPattern = regexp.MustCompile(`/(\[[0-9a-fA-F:.%]+\]:\d+|[^\s,\[\]/]+:\d+)`)
+)
+
+// traceWordings are tried in order, the first matching wording types the event.
+// A description must only be matched by the wordings of its implementation, grpc-go capitalizes
+// the subchannel Created and Deleted events of the subchannel trace while grpc-core doesn't.
+var traceWordings = []traceWording{
+	// grpc-go
+	{ImplementationGo, TraceEventConnectivityState, regexp.MustCompile(`^(?:Channel|Subchannel) Connectivity change to (?P<state>\w+)`)},
+	{ImplementationGo, TraceEventResolverUpdate, regexp.MustCompile(`(?s)^Resolver state updated: (?P<addresses>.*)`)},
+	{ImplementationGo, TraceEventResolverUpdate, regexp.MustCompile(`(?s)^ccResolverWrapper: sending (?:update|new addresses) to cc: (?P<addresses>.*)`)},
+	{ImplementationGo, TraceEventLbPolicy, regexp.MustCompile(`^Channel switches to new LB policy "(?P<policy>[^"]+)"`)},
+	{ImplementationGo, TraceEventSubchannelCreated, regexp.MustCompile(`^Subchannel\(id:(?P<id>\d+)\) created`)},
+	{ImplementationGo, TraceEventSubchannelCreated, regexp.MustCompile(`^Subchannel Created`)},
+	{ImplementationGo, TraceEventSubchannelDeleted, regexp.MustCompile(`^Subchannel\(id:(?P<id>\d+)\) deleted`)},
+	{ImplementationGo, TraceEventSubchannelDeleted, regexp.MustCompile(`^Subchannel Deleted`)},
+	{ImplementationGo, TraceEventServiceConfig, regexp.MustCompile(`(?s)^ccResolverWrapper: got new service config: (?P<config>.*)`)},
+	{ImplementationGo, TraceEventServiceConfig, regexp.MustCompile(`(?s)^Channel has a new service config "(?P<config>.*)"`)},
+
+	// grpc-java
+	{ImplementationJava, TraceEventConnectivityState, regexp.MustCompile(`^Entering (?P<state>\w+) state`)},
+	{ImplementationJava, TraceEventConnectivityState, regexp.MustCompile(`^(?P<state>IDLE|CONNECTING|READY|TRANSIENT_FAILURE|SHUTDOWN)(?:\(.*\))?$`)},
+	{ImplementationJava, TraceEventResolverUpdate, regexp.MustCompile(`(?s)^(?:Address resolved|Resolved address): (?P<addresses>.*)`)},
+	{ImplementationJava, TraceEventLbPolicy, regexp.MustCompile(`^Load balancer changed from \w+ to (?P<policy>\w+)`)},
+	{ImplementationJava, TraceEventSubchannelCreated, regexp.MustCompile(`^Child Subchannel (?:started|created)`)},
+	{ImplementationJava, TraceEventSubchannelCreated, regexp.MustCompile(`^Subchannel for .* created`)},
+	{ImplementationJava, TraceEventServiceConfig, regexp.MustCompile(`^(?:Service config changed|Service config look-up disabled|Fallback to error due to invalid first service config)`)},
+
+	// grpc-core
+	{ImplementationCore, TraceEventConnectivityState, regexp.MustCompile(`^(?:Subchannel c|C)onnectivity state changed to (?P<state>\w+)`)},
+	{ImplementationCore, TraceEventConnectivityState, regexp.MustCompile(`^Channel state change to (?P<state>\w+)`)},
+	{ImplementationCore, TraceEventResolverUpdate, regexp.MustCompile(`^Resolution event: `)},
+	{ImplementationCore, TraceEventLbPolicy, regexp.MustCompile(`^Created new LB policy "(?P<policy>[^"]+)"`)},
+	{ImplementationCore, TraceEventSubchannelCreated, regexp.MustCompile(`^[Ss]ubchannel created`)},
+}
+
+// parseAddresses extracts the addresses of a resolver update
+func parseAddresses(implementation string, text string) []string {
+	pattern := goAddressPattern
+	if implementation == ImplementationJava {
+		pattern = javaAddressPattern
+	}
+	var addresses []string
+	for _, match := range pattern.FindAllStringSubmatch(text, -1) {
+		addresses = append(addresses, match[1])
+	}
+	return addresses
+}
+
+// normalizePolicy turns grpc-java balancer class names like RoundRobinLoadBalancer or CdsLoadBalancer2
+// into policy names like round_robin or cds
+func normalizePolicy(implementation string, policy string) string {
+	if implementation != ImplementationJava {
+		return policy
+	}
+	policy = strings.TrimSuffix(strings.TrimRightFunc(policy, unicode.IsDigit), "LoadBalancer")
+	var b strings.Builder
+	for i, r := range policy {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// apply fills the event from the named groups of the wording
+func (w traceWording) apply(event *TraceEvent, match []string) {
+	event.Type = w.eventType
+	event.Implementation = w.implementation
+	for i, name := range w.pattern.SubexpNames() {
+		value := match[i]
+		switch name {
+		case "state":
+			event.State = value
+		case "addresses":
+			event.Addresses = parseAddresses(w.implementation, value)
+		case "policy":
+			event.LbPolicy = normalizePolicy(w.implementation, value)
+		case "id":
+			fmt.Sscan(value, &event.SubchannelId)
+		case "config":
+			event.ServiceConfig = value
+		}
+	}
+}
+
+// subchannelRefType classifies an event referencing a child subchannel with an unknown wording
+func subchannelRefType(description string) TraceEventType {
+	description = strings.ToLower(description)
+	for _, word := range []string{"delet", "remov", "shut", "destroy"} {
+		if strings.Contains(description, word) {
+			return TraceEventSubchannelDeleted
+		}
+	}
+	for _, word := range []string{"creat", "start", "add"} {
+		if strings.Contains(description, word) {
+			return TraceEventSubchannelCreated
+		}
+	}
+	return TraceEventUnknown
+}
+
+// ParseTraceEvent types a trace event from its description
+func ParseTraceEvent(event *channelzgrpc.ChannelTraceEvent) TraceEvent {
+	res := TraceEvent{
+		Type:        TraceEventUnknown,
+		Severity:    event.GetSeverity().String(),
+		Timestamp:   event.GetTimestamp().AsTime(),
+		Description: event.GetDescription(),
+	}
+	for _, wording := range traceWordings {
+		if match := wording.pattern.FindStringSubmatch(res.Description); match != nil {
+			wording.apply(&res, match)
+			break
+		}
+	}
+	if subchannelRef := event.GetSubchannelRef(); subchannelRef != nil {
+		if res.Type == TraceEventUnknown {
+			res.Type = subchannelRefType(res.Description)
+		}
+		if res.SubchannelId == 0 {
+			res.SubchannelId = subchannelRef.GetSubchannelId()
+		}
+	}
+	return res
+}
+
+// ParseTraceEvents types the trace events of a channel or subchannel
+func ParseTraceEvents(events []*channelzgrpc.ChannelTraceEvent) []TraceEvent {
+	res := make([]TraceEvent, 0, len(events))
+	for _, event := range events {
+		res = append(res, ParseTraceEvent(event))
+	}
+	return res
+}
+
+// ParseTraceEventType validates a trace event type
+func ParseTraceEventType(value string) (TraceEventType, error) {
+	for _, eventType := range traceEventTypes {
+		if string(eventType) == value {
+			return eventType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown trace event type %q, expected one of %v", value, traceEventTypes)
+}
+
+// ParseSeverity validates a trace event severity, with or without the CT_ prefix
+func ParseSeverity(value string) (string, error) {
+	severity := strings.ToUpper(value)
+	if !strings.HasPrefix(severity, "CT_") {
+		severity = "CT_" + severity
+	}
+	if _, ok := channelzgrpc.ChannelTraceEvent_Severity_value[severity]; !ok {
+		return "", fmt.Errorf("unknown severity %q, expected info, warning or error", value)
+	}
+	return severity, nil
+}
+
+// TraceEventFilter selects trace events by severity and type, an empty list matches everything
+type TraceEventFilter struct {
+	Severities []string
+	Types      []TraceEventType
+}
+
+func (f TraceEventFilter) match(event TraceEvent) bool {
+	if len(f.Severities) > 0 && !contains(f.Severities, event.Severity) {
+		return false
+	}
+	if len(f.Types) > 0 && !contains(f.Types, event.Type) {
+		return false
+	}
+	return true
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the events matching the filter
+func (f TraceEventFilter) Filter(events []TraceEvent) []TraceEvent {
+	res := make([]TraceEvent, 0, len(events))
+	for _, event := range events {
+		if f.match(event) {
+			res = append(res, event)
+		}
+	}
+	return res
+}
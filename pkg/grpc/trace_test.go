@@ -0,0 +1,130 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+func traceEvent(description string) *channelzgrpc.ChannelTraceEvent {
+	return &channelzgrpc.ChannelTraceEvent{Description: description, Severity: channelzgrpc.ChannelTraceEvent_CT_INFO}
+}
+
+func TestParseTraceEvent(t *testing.T) {
+	tests := []struct {
+		description string
+		expected    TraceEvent
+	}{
+		{"Channel Connectivity change to READY", TraceEvent{Type: TraceEventConnectivityState, Implementation: ImplementationGo, State: "READY"}},
+		{"Subchannel Connectivity change to TRANSIENT_FAILURE", TraceEvent{Type: TraceEventConnectivityState, Implementation: ImplementationGo, State: "TRANSIENT_FAILURE"}},
+		{`Resolver state updated: {
+  "Addresses": [
+    {
+      "Addr": "10.0.0.1:443",
+      "ServerName": ""
+    },
+    {
+      "Addr": "[::1]:443",
+      "ServerName": ""
+    }
+  ]
+} (resolver returned new addresses)`, TraceEvent{Type: TraceEventResolverUpdate, Implementation: ImplementationGo, Addresses: []string{"10.0.0.1:443", "[::1]:443"}}},
+		{`Channel switches to new LB policy "round_robin"`, TraceEvent{Type: TraceEventLbPolicy, Implementation: ImplementationGo, LbPolicy: "round_robin"}},
+		{"Subchannel(id:12) created", TraceEvent{Type: TraceEventSubchannelCreated, Implementation: ImplementationGo, SubchannelId: 12}},
+		{"Subchannel(id:12) deleted", TraceEvent{Type: TraceEventSubchannelDeleted, Implementation: ImplementationGo, SubchannelId: 12}},
+
+		{"Entering CONNECTING state with picker: Picker{}", TraceEvent{Type: TraceEventConnectivityState, Implementation: ImplementationJava, State: "CONNECTING"}},
+		{"TRANSIENT_FAILURE(UNAVAILABLE: io exception)", TraceEvent{Type: TraceEventConnectivityState, Implementation: ImplementationJava, State: "TRANSIENT_FAILURE"}},
+		{"Address resolved: [[[api.svc/10.0.0.1:443]/{}], [[/10.0.0.2:443]/{}]]", TraceEvent{Type: TraceEventResolverUpdate, Implementation: ImplementationJava, Addresses: []string{"10.0.0.1:443", "10.0.0.2:443"}}},
+		{"Load balancer changed from PickFirstLoadBalancer to RoundRobinLoadBalancer", TraceEvent{Type: TraceEventLbPolicy, Implementation: ImplementationJava, LbPolicy: "round_robin"}},
+		{"Service config changed", TraceEvent{Type: TraceEventServiceConfig, Implementation: ImplementationJava}},
+
+		{"Connectivity state changed to IDLE", TraceEvent{Type: TraceEventConnectivityState, Implementation: ImplementationCore, State: "IDLE"}},
+		{"Subchannel connectivity state changed to SHUTDOWN", TraceEvent{Type: TraceEventConnectivityState, Implementation: ImplementationCore, State: "SHUTDOWN"}},
+		{"Resolution event: Address list became non-empty, Service config changed", TraceEvent{Type: TraceEventResolverUpdate, Implementation: ImplementationCore}},
+		{`Created new LB policy "pick_first"`, TraceEvent{Type: TraceEventLbPolicy, Implementation: ImplementationCore, LbPolicy: "pick_first"}},
+		{"subchannel created", TraceEvent{Type: TraceEventSubchannelCreated, Implementation: ImplementationCore}},
+		{"Subchannel Created", TraceEvent{Type: TraceEventSubchannelCreated, Implementation: ImplementationGo}},
+		{"Subchannel Deleted", TraceEvent{Type: TraceEventSubchannelDeleted, Implementation: ImplementationGo}},
+
+		{"Channel Created", TraceEvent{Type: TraceEventUnknown}},
+	}
+	for _, tt := range tests {
+		event := ParseTraceEvent(traceEvent(tt.description))
+		tt.expected.Description = tt.description
+		tt.expected.Severity = "CT_INFO"
+		tt.expected.Timestamp = event.Timestamp
+		assert.Equal(t, tt.expected, event, tt.description)
+	}
+}
+
+func TestTraceWordingsImplementation(t *testing.T) {
+	samples := map[string][]string{
+		ImplementationGo: {
+			"Subchannel Created",
+			"Subchannel Deleted",
+			"Subchannel(id:12) created",
+			"Subchannel(id:12) deleted",
+			"Channel Connectivity change to READY",
+			`Channel has a new service config "{}"`,
+		},
+		ImplementationJava: {
+			"Child Subchannel started",
+			"Child Subchannel created",
+			"Subchannel for [[[/10.0.0.1:443]/{}]] created",
+			"Entering READY state with picker: Picker{}",
+			"Service config changed",
+		},
+		ImplementationCore: {
+			"Subchannel created",
+			"subchannel created",
+			"Connectivity state changed to READY",
+			"Channel state change to READY",
+			"Resolution event: Service config changed",
+		},
+	}
+	for implementation, descriptions := range samples {
+		for _, description := range descriptions {
+			// No wording of another implementation shadows the sample
+			for _, wording := range traceWordings {
+				if wording.pattern.MatchString(description) {
+					assert.Equal(t, implementation, wording.implementation, description)
+				}
+			}
+			assert.Equal(t, implementation, ParseTraceEvent(traceEvent(description)).Implementation, description)
+		}
+	}
+}
+
+func TestParseTraceEventSubchannelRef(t *testing.T) {
+	event := traceEvent("Child Subchannel shutdown")
+	event.ChildRef = &channelzgrpc.ChannelTraceEvent_SubchannelRef{SubchannelRef: &channelzgrpc.SubchannelRef{SubchannelId: 7}}
+	parsed := ParseTraceEvent(event)
+	assert.Equal(t, TraceEventSubchannelDeleted, parsed.Type)
+	assert.Equal(t, int64(7), parsed.SubchannelId)
+}
+
+func TestTraceEventFilter(t *testing.T) {
+	events := ParseTraceEvents([]*channelzgrpc.ChannelTraceEvent{
+		traceEvent("Channel Created"),
+		traceEvent("Channel Connectivity change to CONNECTING"),
+		{Description: "Channel Connectivity change to TRANSIENT_FAILURE", Severity: channelzgrpc.ChannelTraceEvent_CT_WARNING},
+	})
+	assert.Len(t, TraceEventFilter{}.Filter(events), 3)
+	assert.Len(t, TraceEventFilter{Types: []TraceEventType{TraceEventConnectivityState}}.Filter(events), 2)
+	filtered := TraceEventFilter{Severities: []string{"CT_WARNING"}, Types: []TraceEventType{TraceEventConnectivityState}}.Filter(events)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, "TRANSIENT_FAILURE", filtered[0].State)
+
+	severity, err := ParseSeverity("warning")
+	assert.NoError(t, err)
+	assert.Equal(t, "CT_WARNING", severity)
+	_, err = ParseSeverity("fatal")
+	assert.Error(t, err)
+	_, err = ParseTraceEventType("lb_policy")
+	assert.NoError(t, err)
+	_, err = ParseTraceEventType("lb")
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+const (
+	// DefaultTreeDepth is the default depth limit when resolving references
+	DefaultTreeDepth = 4
+	// MaxTreeDepth is the highest accepted depth limit
+	MaxTreeDepth = 10
+)
+
+// Expand lists the kind of references resolved when building an entity graph
+type Expand struct {
+	Subchannels    bool
+	Sockets        bool
+	NestedChannels bool
+}
+
+// ExpandAll resolves every kind of reference
+var ExpandAll = Expand{Subchannels: true, Sockets: true, NestedChannels: true}
+
+// ParseExpand parses a comma separated list of subchannels, sockets and nestedChannels
+func ParseExpand(value string) (Expand, error) {
+	expand := Expand{}
+	if value == "" {
+		return expand, nil
+	}
+	for _, kind := range strings.Split(value, ",") {
+		switch strings.TrimSpace(kind) {
+		case "subchannels":
+			expand.Subchannels = true
+		case "sockets":
+			expand.Sockets = true
+		case "nestedChannels":
+			expand.NestedChannels = true
+		default:
+			return expand, errors.Errorf("unknown expand value %q", kind)
+		}
+	}
+	return expand, nil
+}
+
+// IsEmpty returns true when no reference is resolved
+func (e Expand) IsEmpty() bool {
+	return !e.Subchannels && !e.Sockets && !e.NestedChannels
+}
+
+// ChannelNode is a channel with its resolved references
+type ChannelNode struct {
+	ChannelResult
+	resolvedRefs
+}
+
+// SubchannelNode is a subchannel with its resolved references
+type SubchannelNode struct {
+	*channelzgrpc.Subchannel
+	resolvedRefs
+}
+
+// resolvedRefs holds the resolved references of a channel or subchannel.
+// Errors lists the entities that could not be fetched.
+type resolvedRefs struct {
+	NestedChannels []*ChannelNode    `json:"nested_channels,omitempty"`
+	Subchannels    []*SubchannelNode `json:"subchannels,omitempty"`
+	Sockets        []SocketResult    `json:"sockets,omitempty"`
+	Errors         []EntityError     `json:"errors,omitempty"`
+}
+
+// ServerNode is a server with its resolved sockets
+type ServerNode struct {
+	*channelzgrpc.Server
+	ListenSockets []SocketResult `json:"listen_sockets,omitempty"`
+	Sockets       []SocketResult `json:"sockets,omitempty"`
+	Errors        []EntityError  `json:"errors,omitempty"`
+}
+
+type treeBuilder struct {
+	c        *ChannelzProxyServer
+	address  string
+	expand   Expand
+	maxDepth int
+}
+
+// GetChannelTree returns the channel with its references resolved recursively up to maxDepth levels
+func (c *ChannelzProxyServer) GetChannelTree(ctx context.Context, address string, channelId int64, expand Expand, maxDepth int) (*ChannelNode, error) {
+	channel, err := c.GetChannel(ctx, address, channelId)
+	if err != nil {
+		return nil, err
+	}
+	return c.ExpandChannel(ctx, address, *channel, expand, maxDepth)
+}
+
+// ExpandChannel resolves the references of an already fetched channel, like one returned by GetTopChannels,
+// recursively up to maxDepth levels
+func (c *ChannelzProxyServer) ExpandChannel(ctx context.Context, address string, channel ChannelResult, expand Expand, maxDepth int) (*ChannelNode, error) {
+	if maxDepth > MaxTreeDepth {
+		maxDepth = MaxTreeDepth
+	}
+	b := &treeBuilder{c: c, address: address, expand: expand, maxDepth: maxDepth}
+	return b.channelNode(ctx, channel, 0)
+}
+
+// ExpandServers resolves the listen sockets and server sockets of servers.
+// Sockets that could not be fetched are reported in the errors of their server node.
+func (c *ChannelzProxyServer) ExpandServers(ctx context.Context, address string, servers []*channelzgrpc.Server, expand Expand) ([]*ServerNode, error) {
+	res := make([]*ServerNode, 0, len(servers))
+	for _, server := range servers {
+		node := &ServerNode{Server: server}
+		if expand.Sockets {
+			serverId := server.GetRef().GetServerId()
+			listenSockets, listenErrors, err := c.GetSockets(ctx, address, SocketRefIds(server.ListenSocket))
+			node.Errors = appendServerErrors(node.Errors, serverId, listenErrors, err)
+			sockets, socketErrors, err := c.GetServerSockets(ctx, address, serverId, 0)
+			node.Errors = appendServerErrors(node.Errors, serverId, socketErrors, err)
+			node.ListenSockets = NewSocketResults(listenSockets)
+			node.Sockets = NewSocketResults(sockets)
+		}
+		res = append(res, node)
+	}
+	return res, nil
+}
+
+// appendServerErrors appends the socket errors of a server, or the error of the whole call under the server id
+// when it failed before any socket was fetched
+func appendServerErrors(errs []EntityError, serverId int64, socketErrors []EntityError, err error) []EntityError {
+	if err != nil && len(socketErrors) == 0 {
+		return append(errs, newEntityError(serverId, err))
+	}
+	return append(errs, socketErrors...)
+}
+
+func (b *treeBuilder) channelNode(ctx context.Context, channel ChannelResult, depth int) (*ChannelNode, error) {
+	node := &ChannelNode{ChannelResult: channel}
+	if depth >= b.maxDepth {
+		return node, nil
+	}
+	err := b.resolveRefs(ctx, &node.resolvedRefs, channel.ChannelRef, channel.SubchannelRef, channel.SocketRef, depth)
+	return node, err
+}
+
+func (b *treeBuilder) subchannelNode(ctx context.Context, subchannel *channelzgrpc.Subchannel, depth int) (*SubchannelNode, error) {
+	node := &SubchannelNode{Subchannel: subchannel}
+	if depth >= b.maxDepth {
+		return node, nil
+	}
+	err := b.resolveRefs(ctx, &node.resolvedRefs, subchannel.ChannelRef, subchannel.SubchannelRef, subchannel.SocketRef, depth)
+	return node, err
+}
+
+func (b *treeBuilder) resolveRefs(ctx context.Context, refs *resolvedRefs, channelRefs []*channelzgrpc.ChannelRef,
+	subchannelRefs []*channelzgrpc.SubchannelRef, socketRefs []*channelzgrpc.SocketRef, depth int) error {
+	if b.expand.NestedChannels {
+		for _, channelRef := range channelRefs {
+			channel, err := b.c.GetChannel(ctx, b.address, channelRef.GetChannelId())
+			if err != nil {
+				refs.Errors = append(refs.Errors, newEntityError(channelRef.GetChannelId(), err))
+				continue
+			}
+			nestedChannel, err := b.channelNode(ctx, *channel, depth+1)
+			if err != nil {
+				return err
+			}
+			refs.NestedChannels = append(refs.NestedChannels, nestedChannel)
+		}
+	}
+
+	if b.expand.Subchannels && len(subchannelRefs) > 0 {
+		subchannelIds := make([]int64, 0, len(subchannelRefs))
+		for _, subchannelRef := range subchannelRefs {
+			subchannelIds = append(subchannelIds, subchannelRef.GetSubchannelId())
+		}
+		subchannels, subchannelErrors, _ := b.c.GetSubchannels(ctx, b.address, subchannelIds)
+		refs.Errors = append(refs.Errors, subchannelErrors...)
+		for _, subchannel := range subchannels {
+			subchannelNode, err := b.subchannelNode(ctx, subchannel, depth+1)
+			if err != nil {
+				return err
+			}
+			refs.Subchannels = append(refs.Subchannels, subchannelNode)
+		}
+	}
+
+	if b.expand.Sockets && len(socketRefs) > 0 {
+		sockets, socketErrors, _ := b.c.GetSockets(ctx, b.address, SocketRefIds(socketRefs))
+		refs.Sockets = NewSocketResults(sockets)
+		refs.Errors = append(refs.Errors, socketErrors...)
+	}
+	return nil
+}
+
+// SocketRefIds returns the ids of socket refs
+func SocketRefIds(socketRefs []*channelzgrpc.SocketRef) []int64 {
+	socketIds := make([]int64, 0, len(socketRefs))
+	for _, socketRef := range socketRefs {
+		socketIds = append(socketIds, socketRef.GetSocketId())
+	}
+	return socketIds
+}
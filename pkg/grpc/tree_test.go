@@ -0,0 +1,100 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseExpand(t *testing.T) {
+	expand, err := ParseExpand("subchannels, sockets")
+	require.NoError(t, err)
+	assert.Equal(t, Expand{Subchannels: true, Sockets: true}, expand)
+
+	_, err = ParseExpand("channels")
+	assert.Error(t, err)
+}
+
+func TestGetChannelTree(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	address := createTestGrpcServer(t, ctx)
+
+	c := NewChannelzProxyServer(zap.NewNop())
+	defer c.connCache.closeAll()
+
+	// The proxy connection to the test server is the channel we inspect
+	channels, _, err := c.GetTopChannels(ctx, address, 0, 0, 0)
+	require.NoError(t, err)
+	var channelId int64
+	for _, channel := range channels {
+		if channel.Data.Target == address {
+			channelId = channel.Ref.ChannelId
+		}
+	}
+	require.NotZero(t, channelId)
+
+	tree, err := c.GetChannelTree(ctx, address, channelId, ExpandAll, DefaultTreeDepth)
+	require.NoError(t, err)
+	require.Len(t, tree.Subchannels, 1)
+	assert.Len(t, tree.Subchannels[0].Sockets, 1)
+
+	tree, err = c.GetChannelTree(ctx, address, channelId, ExpandAll, 1)
+	require.NoError(t, err)
+	require.Len(t, tree.Subchannels, 1)
+	assert.Empty(t, tree.Subchannels[0].Sockets)
+}
+
+// failingServerSocketsServer serves the sockets of server 1 and fails the listen socket and server sockets of server 2
+type failingServerSocketsServer struct {
+	channelzgrpc.UnimplementedChannelzServer
+}
+
+func (s *failingServerSocketsServer) GetServerSockets(ctx context.Context, req *channelzgrpc.GetServerSocketsRequest) (*channelzgrpc.GetServerSocketsResponse, error) {
+	if req.ServerId == 2 {
+		return nil, status.Error(codes.NotFound, "server 2 not found")
+	}
+	return &channelzgrpc.GetServerSocketsResponse{End: true, SocketRef: []*channelzgrpc.SocketRef{{SocketId: 11}}}, nil
+}
+
+func (s *failingServerSocketsServer) GetSocket(ctx context.Context, req *channelzgrpc.GetSocketRequest) (*channelzgrpc.GetSocketResponse, error) {
+	if req.SocketId == 20 {
+		return nil, status.Error(codes.NotFound, "socket 20 not found")
+	}
+	return &channelzgrpc.GetSocketResponse{Socket: &channelzgrpc.Socket{Ref: &channelzgrpc.SocketRef{SocketId: req.SocketId}}}, nil
+}
+
+func TestExpandServersPartialResults(t *testing.T) {
+	address := ServeTestServer(t, func(s grpc.ServiceRegistrar) {
+		channelzgrpc.RegisterChannelzServer(s, &failingServerSocketsServer{})
+	})
+	c := NewChannelzProxyServer(zap.NewNop())
+	defer c.connCache.closeAll()
+
+	servers := []*channelzgrpc.Server{
+		{Ref: &channelzgrpc.ServerRef{ServerId: 1}, ListenSocket: []*channelzgrpc.SocketRef{{SocketId: 10}}},
+		{Ref: &channelzgrpc.ServerRef{ServerId: 2}, ListenSocket: []*channelzgrpc.SocketRef{{SocketId: 20}}},
+	}
+	nodes, err := c.ExpandServers(context.Background(), address, servers, Expand{Sockets: true})
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+
+	assert.Empty(t, nodes[0].Errors)
+	require.Len(t, nodes[0].ListenSockets, 1)
+	require.Len(t, nodes[0].Sockets, 1)
+	assert.Equal(t, int64(11), nodes[0].Sockets[0].Ref.SocketId)
+
+	assert.Empty(t, nodes[1].ListenSockets)
+	assert.Empty(t, nodes[1].Sockets)
+	assert.Equal(t, []EntityError{
+		{Id: 20, Code: "NotFound", Message: "socket 20 not found"},
+		{Id: 2, Code: "NotFound", Message: "server 2 not found"},
+	}, nodes[1].Errors)
+}
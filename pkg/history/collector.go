@@ -0,0 +1,97 @@
+package history
+
+import (
+	"context"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"go.uber.org/zap"
+)
+
+// Collector periodically snapshots targets into the store and prunes snapshots older than the retention
+type Collector struct {
+	c         *grpc.ChannelzProxyServer
+	store     *Store
+	logger    *zap.Logger
+	targets   func() []string
+	interval  time.Duration
+	retention time.Duration
+	timeout   time.Duration
+
+	// targetInterval optionally overrides the interval of a target
+	targetInterval func(host string) (time.Duration, bool)
+	lastCollected  map[string]time.Time
+}
+
+func NewCollector(logger *zap.Logger, c *grpc.ChannelzProxyServer, store *Store, targets func() []string, interval time.Duration, retention time.Duration) *Collector {
+	return &Collector{
+		c:         c,
+		store:     store,
+		logger:    logger.Named("HistoryCollector"),
+		targets:   targets,
+		interval:  interval,
+		retention: retention,
+		timeout:   30 * time.Second,
+
+		lastCollected: make(map[string]time.Time),
+	}
+}
+
+// SetTargetInterval overrides the snapshot interval of some targets.
+// Targets are still checked every collector interval, shorter intervals have no effect.
+func (h *Collector) SetTargetInterval(targetInterval func(host string) (time.Duration, bool)) {
+	h.targetInterval = targetInterval
+}
+
+// due returns true if the host should be snapshotted now
+func (h *Collector) due(host string, now time.Time) bool {
+	if h.targetInterval == nil {
+		return true
+	}
+	interval, ok := h.targetInterval(host)
+	if !ok {
+		return true
+	}
+	// Tolerate ticker jitter to not skip a whole collector interval
+	return now.Sub(h.lastCollected[host]) >= interval-h.interval/10
+}
+
+func (h *Collector) collect(ctx context.Context) {
+	now := time.Now()
+	for _, host := range h.targets() {
+		if !h.due(host, now) {
+			continue
+		}
+		h.lastCollected[host] = now
+		snapshotCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		snapshot, err := TakeSnapshot(snapshotCtx, h.c, host)
+		cancel()
+		if err != nil {
+			h.logger.Warn("Error taking snapshot", zap.String("host", host), zap.Error(err))
+			continue
+		}
+		if err := h.store.Put(snapshot); err != nil {
+			h.logger.Error("Error storing snapshot", zap.String("host", host), zap.Error(err))
+		}
+	}
+	deleted, err := h.store.Prune(time.Now().Add(-h.retention))
+	if err != nil {
+		h.logger.Error("Error pruning snapshots", zap.Error(err))
+	} else if deleted > 0 {
+		h.logger.Debug("Pruned snapshots", zap.Int("deleted", deleted))
+	}
+}
+
+// Run collects snapshots until the context is done
+func (h *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.collect(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// EntitySummary identifies an entity appearing or disappearing between two snapshots
+type EntitySummary struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// EntityChange describes how an entity present in both snapshots changed
+type EntityChange struct {
+	Id           int64            `json:"id"`
+	Name         string           `json:"name,omitempty"`
+	StateFrom    string           `json:"state_from,omitempty"`
+	StateTo      string           `json:"state_to,omitempty"`
+	LbPolicyFrom string           `json:"lb_policy_from,omitempty"`
+	LbPolicyTo   string           `json:"lb_policy_to,omitempty"`
+	Deltas       map[string]int64 `json:"deltas,omitempty"`
+}
+
+// EntityDiff lists the entities of a kind that appeared, disappeared or changed
+type EntityDiff struct {
+	Added   []EntitySummary `json:"added"`
+	Removed []EntitySummary `json:"removed"`
+	Changed []EntityChange  `json:"changed"`
+}
+
+// Diff is the comparison of two snapshots of the same host
+type Diff struct {
+	Host        string     `json:"host"`
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	Channels    EntityDiff `json:"channels"`
+	Subchannels EntityDiff `json:"subchannels"`
+	Servers     EntityDiff `json:"servers"`
+	Sockets     EntityDiff `json:"sockets"`
+}
+
+// diffEntity is the comparable view of an entity
+type diffEntity struct {
+	id       int64
+	name     string
+	state    string
+	lbPolicy string
+	// lbPolicyEvicted is set when the trace dropped the events of the policy, which is then not compared
+	lbPolicyEvicted bool
+	counters        map[string]int64
+}
+
+func channelDataCounters(data *channelzgrpc.ChannelData) map[string]int64 {
+	return map[string]int64{
+		"calls_started":   data.GetCallsStarted(),
+		"calls_succeeded": data.GetCallsSucceeded(),
+		"calls_failed":    data.GetCallsFailed(),
+	}
+}
+
+func channelEntities(channels []*channelzgrpc.Channel) []diffEntity {
+	res := make([]diffEntity, 0, len(channels))
+	for _, channel := range channels {
+		lbPolicy := grpc.DetectLbPolicy(channel)
+		res = append(res, diffEntity{
+			id:              channel.GetRef().GetChannelId(),
+			name:            channel.GetData().GetTarget(),
+			state:           channel.GetData().GetState().GetState().String(),
+			lbPolicy:        lbPolicy.String(),
+			lbPolicyEvicted: lbPolicy.Evicted,
+			counters:        channelDataCounters(channel.GetData()),
+		})
+	}
+	return res
+}
+
+func subchannelEntities(subchannels []*channelzgrpc.Subchannel) []diffEntity {
+	res := make([]diffEntity, 0, len(subchannels))
+	for _, subchannel := range subchannels {
+		res = append(res, diffEntity{
+			id:       subchannel.GetRef().GetSubchannelId(),
+			name:     subchannel.GetData().GetTarget(),
+			state:    subchannel.GetData().GetState().GetState().String(),
+			counters: channelDataCounters(subchannel.GetData()),
+		})
+	}
+	return res
+}
+
+func serverEntities(servers []*channelzgrpc.Server) []diffEntity {
+	res := make([]diffEntity, 0, len(servers))
+	for _, server := range servers {
+		data := server.GetData()
+		res = append(res, diffEntity{
+			id:   server.GetRef().GetServerId(),
+			name: server.GetRef().GetName(),
+			counters: map[string]int64{
+				"calls_started":   data.GetCallsStarted(),
+				"calls_succeeded": data.GetCallsSucceeded(),
+				"calls_failed":    data.GetCallsFailed(),
+			},
+		})
+	}
+	return res
+}
+
+func socketEntities(sockets []*channelzgrpc.Socket) []diffEntity {
+	res := make([]diffEntity, 0, len(sockets))
+	for _, socket := range sockets {
+		data := socket.GetData()
+		name := socket.GetRef().GetName()
+		if remote := grpc.FormatAddress(socket.GetRemote()); remote != "" {
+			name = remote
+		}
+		res = append(res, diffEntity{
+			id:   socket.GetRef().GetSocketId(),
+			name: name,
+			counters: map[string]int64{
+				"streams_started":   data.GetStreamsStarted(),
+				"streams_succeeded": data.GetStreamsSucceeded(),
+				"streams_failed":    data.GetStreamsFailed(),
+				"messages_sent":     data.GetMessagesSent(),
+				"messages_received": data.GetMessagesReceived(),
+				"keep_alives_sent":  data.GetKeepAlivesSent(),
+			},
+		})
+	}
+	return res
+}
+
+func diffEntities(from []diffEntity, to []diffEntity) EntityDiff {
+	diff := EntityDiff{Added: []EntitySummary{}, Removed: []EntitySummary{}, Changed: []EntityChange{}}
+	fromById := make(map[int64]diffEntity, len(from))
+	for _, entity := range from {
+		fromById[entity.id] = entity
+	}
+	toIds := make(map[int64]bool, len(to))
+	for _, entity := range to {
+		toIds[entity.id] = true
+		previous, ok := fromById[entity.id]
+		if !ok {
+			diff.Added = append(diff.Added, EntitySummary{Id: entity.id, Name: entity.name})
+			continue
+		}
+		change := EntityChange{Id: entity.id, Name: entity.name}
+		changed := false
+		if previous.state != entity.state {
+			change.StateFrom, change.StateTo = previous.state, entity.state
+			changed = true
+		}
+		if !previous.lbPolicyEvicted && !entity.lbPolicyEvicted && previous.lbPolicy != entity.lbPolicy {
+			change.LbPolicyFrom, change.LbPolicyTo = previous.lbPolicy, entity.lbPolicy
+			changed = true
+		}
+		for name, value := range entity.counters {
+			if delta := value - previous.counters[name]; delta != 0 {
+				if change.Deltas == nil {
+					change.Deltas = make(map[string]int64)
+				}
+				change.Deltas[name] = delta
+				changed = true
+			}
+		}
+		if changed {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	for _, entity := range from {
+		if !toIds[entity.id] {
+			diff.Removed = append(diff.Removed, EntitySummary{Id: entity.id, Name: entity.name})
+		}
+	}
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Id < diff.Added[j].Id })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Id < diff.Removed[j].Id })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Id < diff.Changed[j].Id })
+	return diff
+}
+
+// Compare returns the differences between two snapshots
+func Compare(from *Snapshot, to *Snapshot) *Diff {
+	return &Diff{
+		Host:        to.Host,
+		From:        from.Time,
+		To:          to.Time,
+		Channels:    diffEntities(channelEntities(from.Channels), channelEntities(to.Channels)),
+		Subchannels: diffEntities(subchannelEntities(from.Subchannels), subchannelEntities(to.Subchannels)),
+		Servers:     diffEntities(serverEntities(from.Servers), serverEntities(to.Servers)),
+		Sockets:     diffEntities(socketEntities(from.Sockets), socketEntities(to.Sockets)),
+	}
+}
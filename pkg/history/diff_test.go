@@ -0,0 +1,48 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	from := testSnapshot("host:1", now.Add(-time.Minute), channelzgrpc.ChannelConnectivityState_CONNECTING)
+	to := testSnapshot("host:1", now, channelzgrpc.ChannelConnectivityState_READY)
+	to.Channels[0].Data.CallsStarted = 10
+	to.Channels[0].Data.Trace = &channelzgrpc.ChannelTrace{Events: []*channelzgrpc.ChannelTraceEvent{
+		{Description: "Channel switches to new LB policy \"round_robin\""},
+	}}
+	to.Channels = append(to.Channels, &channelzgrpc.Channel{Ref: &channelzgrpc.ChannelRef{ChannelId: 2}})
+	to.Sockets = nil
+
+	diff := Compare(from, to)
+	assert.Equal(t, []EntitySummary{{Id: 2}}, diff.Channels.Added)
+	assert.Equal(t, []EntityChange{{
+		Id:         1,
+		StateFrom:  "CONNECTING",
+		StateTo:    "READY",
+		LbPolicyTo: "round_robin",
+		Deltas:     map[string]int64{"calls_started": 10},
+	}}, diff.Channels.Changed)
+	assert.Equal(t, []EntitySummary{{Id: 3, Name: "10.0.0.1:80"}}, diff.Sockets.Removed)
+	assert.Empty(t, diff.Subchannels.Changed)
+}
+
+func TestCompareEvictedLbPolicy(t *testing.T) {
+	now := time.Now()
+	from := testSnapshot("host:1", now.Add(-time.Minute), channelzgrpc.ChannelConnectivityState_READY)
+	from.Channels[0].Data.Trace = &channelzgrpc.ChannelTrace{NumEventsLogged: 1, Events: []*channelzgrpc.ChannelTraceEvent{
+		{Description: "Channel switches to new LB policy \"round_robin\""},
+	}}
+	to := testSnapshot("host:1", now, channelzgrpc.ChannelConnectivityState_READY)
+	to.Channels[0].Data.Trace = &channelzgrpc.ChannelTrace{NumEventsLogged: 40, Events: []*channelzgrpc.ChannelTraceEvent{
+		{Description: "Channel Connectivity change to READY"},
+	}}
+
+	// The wrapped trace doesn't report a policy change
+	assert.Empty(t, Compare(from, to).Channels.Changed)
+}
@@ -0,0 +1,202 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/pkg/errors"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Snapshot is the state of all channelz entities of a host at a point in time
+type Snapshot struct {
+	Host        string                     `json:"host"`
+	Time        time.Time                  `json:"time"`
+	Channels    []*channelzgrpc.Channel    `json:"channels"`
+	Subchannels []*channelzgrpc.Subchannel `json:"subchannels"`
+	Servers     []*channelzgrpc.Server     `json:"servers"`
+	Sockets     []*channelzgrpc.Socket     `json:"sockets"`
+}
+
+// storedSnapshot is the on-disk representation of a snapshot.
+// Entities are encoded with protojson as encoding/json can't decode oneof fields.
+type storedSnapshot struct {
+	Host        string            `json:"host"`
+	Time        time.Time         `json:"time"`
+	Channels    []json.RawMessage `json:"channels"`
+	Subchannels []json.RawMessage `json:"subchannels"`
+	Servers     []json.RawMessage `json:"servers"`
+	Sockets     []json.RawMessage `json:"sockets"`
+}
+
+// TakeSnapshot fetches all channels, subchannels, servers and sockets of a host
+func TakeSnapshot(ctx context.Context, c *grpc.ChannelzProxyServer, host string) (*Snapshot, error) {
+	snapshot := &Snapshot{Host: host, Time: time.Now()}
+	channels, _, err := c.GetTopChannels(ctx, host, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	seenChannels := make(map[int64]bool)
+	seenSubchannels := make(map[int64]bool)
+	seenSockets := make(map[int64]bool)
+	addSockets := func(sockets []grpc.SocketResult) {
+		for _, socket := range sockets {
+			if !seenSockets[socket.GetRef().GetSocketId()] {
+				seenSockets[socket.GetRef().GetSocketId()] = true
+				snapshot.Sockets = append(snapshot.Sockets, socket.Socket)
+			}
+		}
+	}
+	var addChannel func(channel *grpc.ChannelNode)
+	var addSubchannel func(subchannel *grpc.SubchannelNode)
+	addChannel = func(channel *grpc.ChannelNode) {
+		if seenChannels[channel.GetRef().GetChannelId()] {
+			return
+		}
+		seenChannels[channel.GetRef().GetChannelId()] = true
+		snapshot.Channels = append(snapshot.Channels, channel.Channel)
+		for _, nested := range channel.NestedChannels {
+			addChannel(nested)
+		}
+		for _, subchannel := range channel.Subchannels {
+			addSubchannel(subchannel)
+		}
+		addSockets(channel.Sockets)
+	}
+	addSubchannel = func(subchannel *grpc.SubchannelNode) {
+		if seenSubchannels[subchannel.GetRef().GetSubchannelId()] {
+			return
+		}
+		seenSubchannels[subchannel.GetRef().GetSubchannelId()] = true
+		snapshot.Subchannels = append(snapshot.Subchannels, subchannel.Subchannel)
+		for _, nested := range subchannel.NestedChannels {
+			addChannel(nested)
+		}
+		for _, nestedSubchannel := range subchannel.Subchannels {
+			addSubchannel(nestedSubchannel)
+		}
+		addSockets(subchannel.Sockets)
+	}
+
+	for _, channel := range channels {
+		tree, err := c.ExpandChannel(ctx, host, channel, grpc.ExpandAll, grpc.MaxTreeDepth)
+		if err != nil {
+			return nil, err
+		}
+		addChannel(tree)
+	}
+
+	servers, _, err := c.GetServers(ctx, host, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	serverNodes, err := c.ExpandServers(ctx, host, servers, grpc.Expand{Sockets: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range serverNodes {
+		snapshot.Servers = append(snapshot.Servers, server.Server)
+		addSockets(server.ListenSockets)
+		addSockets(server.Sockets)
+	}
+	return snapshot, nil
+}
+
+func marshalMessages[T proto.Message](messages []T) ([]json.RawMessage, error) {
+	res := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		encoded, err := protojson.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, encoded)
+	}
+	return res, nil
+}
+
+func unmarshalMessages[T proto.Message](encoded []json.RawMessage, newMessage func() T) ([]T, error) {
+	res := make([]T, 0, len(encoded))
+	for _, raw := range encoded {
+		message := newMessage()
+		if err := protojson.Unmarshal(raw, message); err != nil {
+			return nil, err
+		}
+		res = append(res, message)
+	}
+	return res, nil
+}
+
+func (s *Snapshot) encode() ([]byte, error) {
+	stored := storedSnapshot{Host: s.Host, Time: s.Time}
+	var err error
+	if stored.Channels, err = marshalMessages(s.Channels); err != nil {
+		return nil, errors.Wrap(err, "failed to encode channels")
+	}
+	if stored.Subchannels, err = marshalMessages(s.Subchannels); err != nil {
+		return nil, errors.Wrap(err, "failed to encode subchannels")
+	}
+	if stored.Servers, err = marshalMessages(s.Servers); err != nil {
+		return nil, errors.Wrap(err, "failed to encode servers")
+	}
+	if stored.Sockets, err = marshalMessages(s.Sockets); err != nil {
+		return nil, errors.Wrap(err, "failed to encode sockets")
+	}
+	return json.Marshal(stored)
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	var stored storedSnapshot
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, errors.Wrap(err, "failed to decode snapshot")
+	}
+	s := &Snapshot{Host: stored.Host, Time: stored.Time}
+	var err error
+	if s.Channels, err = unmarshalMessages(stored.Channels, func() *channelzgrpc.Channel { return &channelzgrpc.Channel{} }); err != nil {
+		return nil, errors.Wrap(err, "failed to decode channels")
+	}
+	if s.Subchannels, err = unmarshalMessages(stored.Subchannels, func() *channelzgrpc.Subchannel { return &channelzgrpc.Subchannel{} }); err != nil {
+		return nil, errors.Wrap(err, "failed to decode subchannels")
+	}
+	if s.Servers, err = unmarshalMessages(stored.Servers, func() *channelzgrpc.Server { return &channelzgrpc.Server{} }); err != nil {
+		return nil, errors.Wrap(err, "failed to decode servers")
+	}
+	if s.Sockets, err = unmarshalMessages(stored.Sockets, func() *channelzgrpc.Socket { return &channelzgrpc.Socket{} }); err != nil {
+		return nil, errors.Wrap(err, "failed to decode sockets")
+	}
+	return s, nil
+}
+
+// Entity returns the entity of the snapshot identified by kind and id, nil if absent
+func (s *Snapshot) Entity(kind string, id int64) proto.Message {
+	switch kind {
+	case "channel":
+		for _, channel := range s.Channels {
+			if channel.GetRef().GetChannelId() == id {
+				return channel
+			}
+		}
+	case "subchannel":
+		for _, subchannel := range s.Subchannels {
+			if subchannel.GetRef().GetSubchannelId() == id {
+				return subchannel
+			}
+		}
+	case "server":
+		for _, server := range s.Servers {
+			if server.GetRef().GetServerId() == id {
+				return server
+			}
+		}
+	case "socket":
+		for _, socket := range s.Sockets {
+			if socket.GetRef().GetSocketId() == id {
+				return socket
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package history
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists snapshots on disk, one bucket per host with snapshots keyed by time
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens or creates the snapshot store at path
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open history store")
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// Put stores the snapshot
+func (s *Store) Put(snapshot *Snapshot) error {
+	encoded, err := snapshot.encode()
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(snapshot.Host))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(timeKey(snapshot.Time), encoded)
+	})
+}
+
+// Range returns the snapshots of host taken between from and to, oldest first
+func (s *Store) Range(host string, from time.Time, to time.Time) ([]*Snapshot, error) {
+	res := make([]*Snapshot, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(host))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		end := timeKey(to)
+		for key, value := cursor.Seek(timeKey(from)); key != nil && string(key) <= string(end); key, value = cursor.Next() {
+			snapshot, err := decodeSnapshot(value)
+			if err != nil {
+				return err
+			}
+			res = append(res, snapshot)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// At returns the most recent snapshot of host taken at or before t, nil if there is none
+func (s *Store) At(host string, t time.Time) (*Snapshot, error) {
+	var res *Snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(host))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		target := timeKey(t)
+		key, value := cursor.Seek(target)
+		if key == nil || string(key) > string(target) {
+			key, value = cursor.Prev()
+		}
+		if key == nil {
+			return nil
+		}
+		var err error
+		res, err = decodeSnapshot(value)
+		return err
+	})
+	return res, err
+}
+
+// Prune deletes all snapshots taken before t and returns the number of deleted snapshots
+func (s *Store) Prune(before time.Time) (int, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		limit := timeKey(before)
+		return tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			cursor := bucket.Cursor()
+			for key, _ := cursor.First(); key != nil && string(key) < string(limit); key, _ = cursor.Next() {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+	})
+	return deleted, err
+}
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func openTestStore(t *testing.T) *Store {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func testSnapshot(host string, t time.Time, state channelzgrpc.ChannelConnectivityState_State) *Snapshot {
+	return &Snapshot{
+		Host: host,
+		Time: t,
+		Channels: []*channelzgrpc.Channel{{
+			Ref:  &channelzgrpc.ChannelRef{ChannelId: 1},
+			Data: &channelzgrpc.ChannelData{State: &channelzgrpc.ChannelConnectivityState{State: state}},
+		}},
+		Sockets: []*channelzgrpc.Socket{{
+			Ref: &channelzgrpc.SocketRef{SocketId: 3},
+			Remote: &channelzgrpc.Address{Address: &channelzgrpc.Address_TcpipAddress{TcpipAddress: &channelzgrpc.Address_TcpIpAddress{
+				IpAddress: net.ParseIP("10.0.0.1").To4(), Port: 80,
+			}}},
+		}},
+	}
+}
+
+func TestStoreRange(t *testing.T) {
+	store := openTestStore(t)
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, store.Put(testSnapshot("host:1", start.Add(time.Duration(i)*time.Minute), channelzgrpc.ChannelConnectivityState_READY)))
+	}
+	require.NoError(t, store.Put(testSnapshot("host:2", start, channelzgrpc.ChannelConnectivityState_IDLE)))
+
+	snapshots, err := store.Range("host:1", start.Add(time.Minute), start.Add(3*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, snapshots, 3)
+	assert.True(t, snapshots[0].Time.Equal(start.Add(time.Minute)))
+
+	socket := snapshots[0].Entity("socket", 3)
+	require.NotNil(t, socket)
+	assert.True(t, proto.Equal(testSnapshot("", start, 0).Sockets[0], socket))
+	assert.Nil(t, snapshots[0].Entity("channel", 2))
+}
+
+func TestStoreAt(t *testing.T) {
+	store := openTestStore(t)
+	start := time.Now().Add(-time.Hour)
+	require.NoError(t, store.Put(testSnapshot("host:1", start, channelzgrpc.ChannelConnectivityState_IDLE)))
+	require.NoError(t, store.Put(testSnapshot("host:1", start.Add(time.Minute), channelzgrpc.ChannelConnectivityState_READY)))
+
+	snapshot, err := store.At("host:1", start.Add(30*time.Second))
+	require.NoError(t, err)
+	require.NotNil(t, snapshot)
+	assert.True(t, snapshot.Time.Equal(start))
+
+	snapshot, err = store.At("host:1", start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, snapshot.Time.Equal(start.Add(time.Minute)))
+
+	snapshot, err = store.At("host:1", start.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, snapshot)
+}
+
+func TestStorePrune(t *testing.T) {
+	store := openTestStore(t)
+	now := time.Now()
+	require.NoError(t, store.Put(testSnapshot("host:1", now.Add(-2*time.Hour), channelzgrpc.ChannelConnectivityState_READY)))
+	require.NoError(t, store.Put(testSnapshot("host:1", now, channelzgrpc.ChannelConnectivityState_READY)))
+
+	deleted, err := store.Prune(now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	snapshots, err := store.Range("host:1", now.Add(-3*time.Hour), now)
+	require.NoError(t, err)
+	assert.Len(t, snapshots, 1)
+}
@@ -0,0 +1,186 @@
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// Sources of a connectivity state observation
+const (
+	SourceTrace   = "trace"
+	SourceHistory = "history"
+	SourceLive    = "live"
+)
+
+// StateObservation is a connectivity state seen at a point in time
+type StateObservation struct {
+	Time   time.Time
+	State  string
+	Source string
+}
+
+// StatePeriod is a time range spent in a connectivity state
+type StatePeriod struct {
+	State   string    `json:"state"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	DwellMs int64     `json:"dwell_ms"`
+	Source  string    `json:"source"`
+	// Current is set on the state the entity is still in
+	Current bool `json:"current,omitempty"`
+}
+
+// EntityTimeline is the sequence of connectivity states of a channel or a subchannel
+type EntityTimeline struct {
+	Kind    string        `json:"kind"`
+	Id      int64         `json:"id"`
+	Name    string        `json:"name,omitempty"`
+	Periods []StatePeriod `json:"periods"`
+	// Transitions is the number of state changes
+	Transitions int `json:"transitions"`
+	// DwellMs is the total time spent in each state
+	DwellMs map[string]int64 `json:"dwell_ms"`
+}
+
+// Timeline is the connectivity state timeline of a channel and of its subchannels
+type Timeline struct {
+	Channel     EntityTimeline   `json:"channel"`
+	Subchannels []EntityTimeline `json:"subchannels"`
+}
+
+// traceObservations returns the connectivity state changes logged in a trace
+func traceObservations(trace *channelzgrpc.ChannelTrace) []StateObservation {
+	var res []StateObservation
+	for _, event := range grpc.ParseTraceEvents(trace.GetEvents()) {
+		if event.Type == grpc.TraceEventConnectivityState {
+			res = append(res, StateObservation{Time: event.Timestamp, State: event.State, Source: SourceTrace})
+		}
+	}
+	return res
+}
+
+// buildEntityTimeline merges the observations of an entity into state periods ending at end.
+// The trace is authoritative for the time range it covers: the whole life of the entity when no event
+// was evicted, polled history states only fill the time before its oldest event otherwise.
+// live is the current state, nil for deleted entities.
+func buildEntityTimeline(kind string, id int64, name string, trace *channelzgrpc.ChannelTrace,
+	polled []StateObservation, live *StateObservation, end time.Time) EntityTimeline {
+	observations := traceObservations(trace)
+	evicted := trace.GetNumEventsLogged() > int64(len(trace.GetEvents()))
+	switch {
+	case len(observations) == 0:
+		observations = append(observations, polled...)
+	case evicted:
+		traceStart := trace.GetEvents()[0].GetTimestamp().AsTime()
+		for _, observation := range polled {
+			if observation.Time.Before(traceStart) {
+				observations = append(observations, observation)
+			}
+		}
+	}
+	sort.SliceStable(observations, func(i, j int) bool { return observations[i].Time.Before(observations[j].Time) })
+	if live != nil && live.State != "" && (len(observations) == 0 || observations[len(observations)-1].State != live.State) {
+		observations = append(observations, *live)
+	}
+
+	timeline := EntityTimeline{Kind: kind, Id: id, Name: name, Periods: []StatePeriod{}, DwellMs: map[string]int64{}}
+	for _, observation := range observations {
+		if n := len(timeline.Periods); n > 0 && timeline.Periods[n-1].State == observation.State {
+			continue
+		}
+		timeline.Periods = append(timeline.Periods, StatePeriod{
+			State:  observation.State,
+			Start:  observation.Time,
+			Source: observation.Source,
+		})
+	}
+	for i := range timeline.Periods {
+		period := &timeline.Periods[i]
+		if i+1 < len(timeline.Periods) {
+			period.End = timeline.Periods[i+1].Start
+		} else {
+			period.End = end
+			period.Current = live != nil
+		}
+		// Trace timestamps come from the clock of the target
+		if period.End.Before(period.Start) {
+			period.End = period.Start
+		}
+		period.DwellMs = period.End.Sub(period.Start).Milliseconds()
+		timeline.DwellMs[period.State] += period.DwellMs
+	}
+	if len(timeline.Periods) > 0 {
+		timeline.Transitions = len(timeline.Periods) - 1
+	}
+	return timeline
+}
+
+// polledStates returns the states of an entity in the snapshots
+func polledStates(snapshots []*Snapshot, kind string, id int64) []StateObservation {
+	var res []StateObservation
+	for _, snapshot := range snapshots {
+		switch entity := snapshot.Entity(kind, id).(type) {
+		case *channelzgrpc.Channel:
+			res = append(res, StateObservation{Time: snapshot.Time, State: entity.GetData().GetState().GetState().String(), Source: SourceHistory})
+		case *channelzgrpc.Subchannel:
+			res = append(res, StateObservation{Time: snapshot.Time, State: entity.GetData().GetState().GetState().String(), Source: SourceHistory})
+		}
+	}
+	return res
+}
+
+// BuildTimeline rebuilds the connectivity states of a channel and its subchannels from their traces and,
+// when history is available, from their polled states. Subchannels of the channel only found in the
+// snapshots are included.
+func BuildTimeline(channel *channelzgrpc.Channel, subchannels []*channelzgrpc.Subchannel, snapshots []*Snapshot, now time.Time) Timeline {
+	channelId := channel.GetRef().GetChannelId()
+	timeline := Timeline{
+		Channel: buildEntityTimeline("channel", channelId, channel.GetData().GetTarget(), channel.GetData().GetTrace(),
+			polledStates(snapshots, "channel", channelId),
+			&StateObservation{Time: now, State: channel.GetData().GetState().GetState().String(), Source: SourceLive}, now),
+		Subchannels: []EntityTimeline{},
+	}
+
+	live := make(map[int64]bool, len(subchannels))
+	for _, subchannel := range subchannels {
+		id := subchannel.GetRef().GetSubchannelId()
+		live[id] = true
+		timeline.Subchannels = append(timeline.Subchannels, buildEntityTimeline("subchannel", id,
+			subchannel.GetData().GetTarget(), subchannel.GetData().GetTrace(), polledStates(snapshots, "subchannel", id),
+			&StateObservation{Time: now, State: subchannel.GetData().GetState().GetState().String(), Source: SourceLive}, now))
+	}
+
+	// Subchannels deleted since they were polled
+	for _, snapshot := range snapshots {
+		snapshotChannel, ok := snapshot.Entity("channel", channelId).(*channelzgrpc.Channel)
+		if !ok {
+			continue
+		}
+		for _, ref := range snapshotChannel.GetSubchannelRef() {
+			id := ref.GetSubchannelId()
+			if live[id] {
+				continue
+			}
+			live[id] = true
+			subchannel, lastSeen := lastSubchannel(snapshots, id)
+			timeline.Subchannels = append(timeline.Subchannels, buildEntityTimeline("subchannel", id,
+				subchannel.GetData().GetTarget(), subchannel.GetData().GetTrace(),
+				polledStates(snapshots, "subchannel", id), nil, lastSeen))
+		}
+	}
+	sort.Slice(timeline.Subchannels, func(i, j int) bool { return timeline.Subchannels[i].Id < timeline.Subchannels[j].Id })
+	return timeline
+}
+
+// lastSubchannel returns the most recent snapshot of a subchannel with its time, nil if it was never polled
+func lastSubchannel(snapshots []*Snapshot, id int64) (*channelzgrpc.Subchannel, time.Time) {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if subchannel, ok := snapshots[i].Entity("subchannel", id).(*channelzgrpc.Subchannel); ok {
+			return subchannel, snapshots[i].Time
+		}
+	}
+	return nil, time.Time{}
+}
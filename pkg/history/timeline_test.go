@@ -0,0 +1,95 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func stateTrace(start time.Time, descriptions ...string) *channelzgrpc.ChannelTrace {
+	trace := &channelzgrpc.ChannelTrace{}
+	for i, description := range descriptions {
+		trace.Events = append(trace.Events, &channelzgrpc.ChannelTraceEvent{
+			Description: description,
+			Timestamp:   timestamppb.New(start.Add(time.Duration(i) * time.Second)),
+		})
+	}
+	return trace
+}
+
+func TestBuildTimeline(t *testing.T) {
+	now := time.Unix(10000, 0).UTC()
+	traceStart := now.Add(-10 * time.Second)
+	channel := &channelzgrpc.Channel{
+		Ref: &channelzgrpc.ChannelRef{ChannelId: 1},
+		Data: &channelzgrpc.ChannelData{
+			Target: "dns:///api:443",
+			State:  &channelzgrpc.ChannelConnectivityState{State: channelzgrpc.ChannelConnectivityState_READY},
+			Trace: stateTrace(traceStart,
+				"Channel Connectivity change to CONNECTING",
+				"Channel Connectivity change to READY"),
+		},
+	}
+	// Older events were evicted from the trace
+	channel.Data.Trace.NumEventsLogged = 10
+	subchannel := &channelzgrpc.Subchannel{
+		Ref: &channelzgrpc.SubchannelRef{SubchannelId: 4},
+		Data: &channelzgrpc.ChannelData{
+			Target: "10.0.0.1:443",
+			State:  &channelzgrpc.ChannelConnectivityState{State: channelzgrpc.ChannelConnectivityState_READY},
+			Trace: stateTrace(traceStart,
+				"Subchannel Connectivity change to CONNECTING",
+				"Subchannel Connectivity change to TRANSIENT_FAILURE",
+				"Subchannel Connectivity change to CONNECTING",
+				"Subchannel Connectivity change to READY"),
+		},
+	}
+
+	// Polled states before the trace fill the older part of the timeline
+	older := testSnapshot("host:1", now.Add(-time.Minute), channelzgrpc.ChannelConnectivityState_IDLE)
+	older.Channels[0].SubchannelRef = []*channelzgrpc.SubchannelRef{{SubchannelId: 3}}
+	older.Subchannels = []*channelzgrpc.Subchannel{{
+		Ref:  &channelzgrpc.SubchannelRef{SubchannelId: 3},
+		Data: &channelzgrpc.ChannelData{Target: "10.0.0.2:443", State: &channelzgrpc.ChannelConnectivityState{State: channelzgrpc.ChannelConnectivityState_READY}},
+	}}
+	recent := testSnapshot("host:1", now.Add(-5*time.Second), channelzgrpc.ChannelConnectivityState_READY)
+
+	timeline := BuildTimeline(channel, []*channelzgrpc.Subchannel{subchannel}, []*Snapshot{older, recent}, now)
+
+	assert.Equal(t, []StatePeriod{
+		{State: "IDLE", Start: now.Add(-time.Minute), End: traceStart, DwellMs: 50000, Source: SourceHistory},
+		{State: "CONNECTING", Start: traceStart, End: traceStart.Add(time.Second), DwellMs: 1000, Source: SourceTrace},
+		{State: "READY", Start: traceStart.Add(time.Second), End: now, DwellMs: 9000, Source: SourceTrace, Current: true},
+	}, timeline.Channel.Periods)
+	assert.Equal(t, 2, timeline.Channel.Transitions)
+
+	require.Len(t, timeline.Subchannels, 2)
+	deleted := timeline.Subchannels[0]
+	assert.Equal(t, int64(3), deleted.Id)
+	assert.Equal(t, "10.0.0.2:443", deleted.Name)
+	require.Len(t, deleted.Periods, 1)
+	assert.False(t, deleted.Periods[0].Current)
+
+	live := timeline.Subchannels[1]
+	assert.Equal(t, 3, live.Transitions)
+	assert.Equal(t, map[string]int64{"CONNECTING": 2000, "TRANSIENT_FAILURE": 1000, "READY": 7000}, live.DwellMs)
+}
+
+func TestBuildTimelineLiveState(t *testing.T) {
+	now := time.Unix(10000, 0).UTC()
+	// A trace without connectivity events relies on the live state
+	channel := &channelzgrpc.Channel{
+		Ref: &channelzgrpc.ChannelRef{ChannelId: 1},
+		Data: &channelzgrpc.ChannelData{
+			State: &channelzgrpc.ChannelConnectivityState{State: channelzgrpc.ChannelConnectivityState_IDLE},
+			Trace: stateTrace(now.Add(-time.Second), "Channel created"),
+		},
+	}
+	timeline := BuildTimeline(channel, nil, nil, now)
+	assert.Equal(t, []StatePeriod{{State: "IDLE", Start: now, End: now, Source: SourceLive, Current: true}}, timeline.Channel.Periods)
+	assert.Empty(t, timeline.Subchannels)
+}
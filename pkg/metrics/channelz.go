@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+var (
+	channelLabels    = []string{"address", "channel_id", "channel_target", "lb_policy"}
+	subchannelLabels = []string{"address", "channel_id", "channel_target", "lb_policy", "subchannel_id", "subchannel_address"}
+	serverLabels     = []string{"address", "server_id"}
+	socketLabels     = []string{"address", "socket_id", "socket_name", "parent_kind", "parent_id"}
+
+	upDesc = prometheus.NewDesc("channelz_up",
+		"Whether the channelz endpoint could be scraped", []string{"address"}, nil)
+	scrapeDurationDesc = prometheus.NewDesc("channelz_scrape_duration_seconds",
+		"Duration of the channelz scrape", []string{"address"}, nil)
+
+	channelCallsStartedDesc = prometheus.NewDesc("channelz_channel_calls_started_total",
+		"Number of calls started on the channel", channelLabels, nil)
+	channelCallsSucceededDesc = prometheus.NewDesc("channelz_channel_calls_succeeded_total",
+		"Number of calls succeeded on the channel", channelLabels, nil)
+	channelCallsFailedDesc = prometheus.NewDesc("channelz_channel_calls_failed_total",
+		"Number of calls failed on the channel", channelLabels, nil)
+	channelStateDesc = prometheus.NewDesc("channelz_channel_connectivity_state",
+		"Connectivity state of the channel, 1 for the current state", append(channelLabels, "state"), nil)
+
+	subchannelCallsStartedDesc = prometheus.NewDesc("channelz_subchannel_calls_started_total",
+		"Number of calls started on the subchannel", subchannelLabels, nil)
+	subchannelCallsSucceededDesc = prometheus.NewDesc("channelz_subchannel_calls_succeeded_total",
+		"Number of calls succeeded on the subchannel", subchannelLabels, nil)
+	subchannelCallsFailedDesc = prometheus.NewDesc("channelz_subchannel_calls_failed_total",
+		"Number of calls failed on the subchannel", subchannelLabels, nil)
+	subchannelStateDesc = prometheus.NewDesc("channelz_subchannel_connectivity_state",
+		"Connectivity state of the subchannel, 1 for the current state", append(subchannelLabels, "state"), nil)
+
+	serverCallsStartedDesc = prometheus.NewDesc("channelz_server_calls_started_total",
+		"Number of calls started on the server", serverLabels, nil)
+	serverCallsSucceededDesc = prometheus.NewDesc("channelz_server_calls_succeeded_total",
+		"Number of calls succeeded on the server", serverLabels, nil)
+	serverCallsFailedDesc = prometheus.NewDesc("channelz_server_calls_failed_total",
+		"Number of calls failed on the server", serverLabels, nil)
+
+	socketStreamsStartedDesc = prometheus.NewDesc("channelz_socket_streams_started_total",
+		"Number of streams started on the socket", socketLabels, nil)
+	socketStreamsSucceededDesc = prometheus.NewDesc("channelz_socket_streams_succeeded_total",
+		"Number of streams ended successfully on the socket", socketLabels, nil)
+	socketStreamsFailedDesc = prometheus.NewDesc("channelz_socket_streams_failed_total",
+		"Number of streams ended unsuccessfully on the socket", socketLabels, nil)
+	socketMessagesSentDesc = prometheus.NewDesc("channelz_socket_messages_sent_total",
+		"Number of grpc messages sent on the socket", socketLabels, nil)
+	socketMessagesReceivedDesc = prometheus.NewDesc("channelz_socket_messages_received_total",
+		"Number of grpc messages received on the socket", socketLabels, nil)
+	socketKeepAlivesSentDesc = prometheus.NewDesc("channelz_socket_keepalives_sent_total",
+		"Number of keep alives sent on the socket", socketLabels, nil)
+	socketLocalFlowControlWindowDesc = prometheus.NewDesc("channelz_socket_local_flow_control_window_bytes",
+		"Bytes the local endpoint can send", socketLabels, nil)
+	socketRemoteFlowControlWindowDesc = prometheus.NewDesc("channelz_socket_remote_flow_control_window_bytes",
+		"Bytes the remote endpoint can send", socketLabels, nil)
+)
+
+// ChannelzCollector exports channelz data of a list of targets as prometheus metrics.
+// Targets are scraped through the ChannelzProxyServer on each collection.
+type ChannelzCollector struct {
+	c       *grpc.ChannelzProxyServer
+	logger  *zap.Logger
+	targets func() []string
+	timeout time.Duration
+}
+
+// NewChannelzCollector creates a collector scraping the addresses returned by targets
+func NewChannelzCollector(logger *zap.Logger, c *grpc.ChannelzProxyServer, targets func() []string, timeout time.Duration) *ChannelzCollector {
+	return &ChannelzCollector{
+		c:       c,
+		logger:  logger.Named("ChannelzCollector"),
+		targets: targets,
+		timeout: timeout,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (cc *ChannelzCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		upDesc, scrapeDurationDesc,
+		channelCallsStartedDesc, channelCallsSucceededDesc, channelCallsFailedDesc, channelStateDesc,
+		subchannelCallsStartedDesc, subchannelCallsSucceededDesc, subchannelCallsFailedDesc, subchannelStateDesc,
+		serverCallsStartedDesc, serverCallsSucceededDesc, serverCallsFailedDesc,
+		socketStreamsStartedDesc, socketStreamsSucceededDesc, socketStreamsFailedDesc,
+		socketMessagesSentDesc, socketMessagesReceivedDesc, socketKeepAlivesSentDesc,
+		socketLocalFlowControlWindowDesc, socketRemoteFlowControlWindowDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (cc *ChannelzCollector) Collect(ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, address := range cc.targets() {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), cc.timeout)
+			defer cancel()
+			start := time.Now()
+			err := cc.collectTarget(ctx, ch, address)
+			up := 1.0
+			if err != nil {
+				cc.logger.Warn("Error scraping channelz", zap.String("address", address), zap.Error(err))
+				up = 0
+			}
+			ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up, address)
+			ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, time.Since(start).Seconds(), address)
+		}(address)
+	}
+	wg.Wait()
+}
+
+func (cc *ChannelzCollector) collectTarget(ctx context.Context, ch chan<- prometheus.Metric, address string) error {
+	channels, _, err := cc.c.GetTopChannels(ctx, address, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+	servers, _, err := cc.c.GetServers(ctx, address, 0, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	seenSubchannels := make(map[int64]bool)
+	for _, channel := range channels {
+		tree, err := cc.c.ExpandChannel(ctx, address, channel, grpc.ExpandAll, grpc.MaxTreeDepth)
+		if err != nil {
+			cc.logger.Warn("Error expanding channel, skipping it", zap.String("address", address),
+				zap.Int64("channelId", channel.GetRef().GetChannelId()), zap.Error(err))
+			continue
+		}
+		collectChannel(ch, address, tree, seenSubchannels)
+	}
+
+	serverNodes, err := cc.c.ExpandServers(ctx, address, servers, grpc.Expand{Sockets: true})
+	if err != nil {
+		return err
+	}
+	for _, server := range serverNodes {
+		collectServer(ch, address, server)
+	}
+	return nil
+}
+
+// collectChannel exports a channel with its sockets, nested channels and subchannels.
+// Seen subchannels are skipped as they can be referenced by several channels.
+func collectChannel(ch chan<- prometheus.Metric, address string, channel *grpc.ChannelNode, seen map[int64]bool) {
+	data := channel.GetData()
+	channelId := strconv.FormatInt(channel.GetRef().GetChannelId(), 10)
+	// The evicted marker would create new series once the trace wraps
+	lbPolicy := channel.LbPolicy
+	if lbPolicy == grpc.LbPolicyEvicted {
+		lbPolicy = ""
+	}
+	labels := []string{address, channelId, data.GetTarget(), lbPolicy}
+	collectCalls(ch, labels, data, channelCallsStartedDesc, channelCallsSucceededDesc, channelCallsFailedDesc)
+	collectState(ch, channelStateDesc, labels, data.GetState().GetState())
+
+	for _, socket := range channel.Sockets {
+		collectSocket(ch, address, "channel", channelId, socket.Socket)
+	}
+	for _, nested := range channel.NestedChannels {
+		collectChannel(ch, address, nested, seen)
+	}
+	for _, subchannel := range channel.Subchannels {
+		if seen[subchannel.GetRef().GetSubchannelId()] {
+			continue
+		}
+		seen[subchannel.GetRef().GetSubchannelId()] = true
+		subchannelData := subchannel.GetData()
+		subchannelId := strconv.FormatInt(subchannel.GetRef().GetSubchannelId(), 10)
+		subchannelLabels := append(append([]string{}, labels...), subchannelId, subchannelData.GetTarget())
+		collectCalls(ch, subchannelLabels, subchannelData, subchannelCallsStartedDesc, subchannelCallsSucceededDesc, subchannelCallsFailedDesc)
+		collectState(ch, subchannelStateDesc, subchannelLabels, subchannelData.GetState().GetState())
+		for _, socket := range subchannel.Sockets {
+			collectSocket(ch, address, "subchannel", subchannelId, socket.Socket)
+		}
+	}
+}
+
+func collectServer(ch chan<- prometheus.Metric, address string, server *grpc.ServerNode) {
+	data := server.GetData()
+	serverId := strconv.FormatInt(server.GetRef().GetServerId(), 10)
+	labels := []string{address, serverId}
+	ch <- prometheus.MustNewConstMetric(serverCallsStartedDesc, prometheus.CounterValue, float64(data.GetCallsStarted()), labels...)
+	ch <- prometheus.MustNewConstMetric(serverCallsSucceededDesc, prometheus.CounterValue, float64(data.GetCallsSucceeded()), labels...)
+	ch <- prometheus.MustNewConstMetric(serverCallsFailedDesc, prometheus.CounterValue, float64(data.GetCallsFailed()), labels...)
+	for _, socket := range server.Sockets {
+		collectSocket(ch, address, "server", serverId, socket.Socket)
+	}
+}
+
+func collectCalls(ch chan<- prometheus.Metric, labels []string, data *channelzgrpc.ChannelData, started, succeeded, failed *prometheus.Desc) {
+	ch <- prometheus.MustNewConstMetric(started, prometheus.CounterValue, float64(data.GetCallsStarted()), labels...)
+	ch <- prometheus.MustNewConstMetric(succeeded, prometheus.CounterValue, float64(data.GetCallsSucceeded()), labels...)
+	ch <- prometheus.MustNewConstMetric(failed, prometheus.CounterValue, float64(data.GetCallsFailed()), labels...)
+}
+
+// collectState emits one serie per connectivity state with 1 for the current state
+func collectState(ch chan<- prometheus.Metric, desc *prometheus.Desc, labels []string, current channelzgrpc.ChannelConnectivityState_State) {
+	for value, name := range channelzgrpc.ChannelConnectivityState_State_name {
+		if value == int32(channelzgrpc.ChannelConnectivityState_UNKNOWN) {
+			continue
+		}
+		gauge := 0.0
+		if value == int32(current) {
+			gauge = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, gauge, append(append([]string{}, labels...), name)...)
+	}
+}
+
+func collectSocket(ch chan<- prometheus.Metric, address string, parentKind string, parentId string, socket *channelzgrpc.Socket) {
+	data := socket.GetData()
+	labels := []string{address, strconv.FormatInt(socket.GetRef().GetSocketId(), 10), socket.GetRef().GetName(), parentKind, parentId}
+	counters := map[*prometheus.Desc]int64{
+		socketStreamsStartedDesc:   data.GetStreamsStarted(),
+		socketStreamsSucceededDesc: data.GetStreamsSucceeded(),
+		socketStreamsFailedDesc:    data.GetStreamsFailed(),
+		socketMessagesSentDesc:     data.GetMessagesSent(),
+		socketMessagesReceivedDesc: data.GetMessagesReceived(),
+		socketKeepAlivesSentDesc:   data.GetKeepAlivesSent(),
+	}
+	for desc, value := range counters {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+	}
+	if data.GetLocalFlowControlWindow() != nil {
+		ch <- prometheus.MustNewConstMetric(socketLocalFlowControlWindowDesc, prometheus.GaugeValue, float64(data.GetLocalFlowControlWindow().GetValue()), labels...)
+	}
+	if data.GetRemoteFlowControlWindow() != nil {
+		ch <- prometheus.MustNewConstMetric(socketRemoteFlowControlWindowDesc, prometheus.GaugeValue, float64(data.GetRemoteFlowControlWindow().GetValue()), labels...)
+	}
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	googlegrpc "google.golang.org/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func createTestChannelzServer(t *testing.T) string {
+	return grpc.ServeTestServer(t, channelz.RegisterChannelzServiceToServer)
+}
+
+func gatherNames(t *testing.T, registry *prometheus.Registry) map[string]bool {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	return names
+}
+
+func TestChannelzCollector(t *testing.T) {
+	address := createTestChannelzServer(t)
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewChannelzCollector(zap.NewNop(), c, func() []string {
+		return []string{address}
+	}, 5*time.Second))
+
+	// First scrape creates the proxy channel to the target, the second one sees its subchannel and socket
+	gatherNames(t, registry)
+	names := gatherNames(t, registry)
+	for _, name := range []string{
+		"channelz_up",
+		"channelz_channel_calls_started_total",
+		"channelz_channel_connectivity_state",
+		"channelz_subchannel_connectivity_state",
+		"channelz_server_calls_started_total",
+		"channelz_socket_streams_started_total",
+	} {
+		assert.True(t, names[name], name)
+	}
+}
+
+func TestChannelzCollectorDown(t *testing.T) {
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewChannelzCollector(zap.NewNop(), c, func() []string {
+		return []string{"localhost:1"}
+	}, time.Second))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	var up *dto.MetricFamily
+	for _, family := range families {
+		if family.GetName() == "channelz_up" {
+			up = family
+		}
+	}
+	require.NotNil(t, up, "channelz_up is exported")
+	require.Len(t, up.GetMetric(), 1)
+	assert.Equal(t, 0.0, up.GetMetric()[0].GetGauge().GetValue())
+}
+
+// nestedChannelServer serves the top channel 1 with the socket 6 and the nested channel 2,
+// which has the subchannel 3 and the socket 4
+type nestedChannelServer struct {
+	channelzgrpc.UnimplementedChannelzServer
+	getChannelCalls int64
+}
+
+func (s *nestedChannelServer) GetTopChannels(ctx context.Context, req *channelzgrpc.GetTopChannelsRequest) (*channelzgrpc.GetTopChannelsResponse, error) {
+	return &channelzgrpc.GetTopChannelsResponse{End: true, Channel: []*channelzgrpc.Channel{{
+		Ref:        &channelzgrpc.ChannelRef{ChannelId: 1},
+		Data:       &channelzgrpc.ChannelData{Target: "xds:///api"},
+		ChannelRef: []*channelzgrpc.ChannelRef{{ChannelId: 2}},
+		SocketRef:  []*channelzgrpc.SocketRef{{SocketId: 6}},
+	}}}, nil
+}
+
+func (s *nestedChannelServer) GetChannel(ctx context.Context, req *channelzgrpc.GetChannelRequest) (*channelzgrpc.GetChannelResponse, error) {
+	atomic.AddInt64(&s.getChannelCalls, 1)
+	if req.ChannelId != 2 {
+		return nil, status.Error(codes.NotFound, "channel not found")
+	}
+	return &channelzgrpc.GetChannelResponse{Channel: &channelzgrpc.Channel{
+		Ref:           &channelzgrpc.ChannelRef{ChannelId: 2},
+		Data:          &channelzgrpc.ChannelData{Target: "xds-server:443", CallsStarted: 4},
+		SubchannelRef: []*channelzgrpc.SubchannelRef{{SubchannelId: 3}},
+		SocketRef:     []*channelzgrpc.SocketRef{{SocketId: 4}},
+	}}, nil
+}
+
+func (s *nestedChannelServer) GetSubchannel(ctx context.Context, req *channelzgrpc.GetSubchannelRequest) (*channelzgrpc.GetSubchannelResponse, error) {
+	return &channelzgrpc.GetSubchannelResponse{Subchannel: &channelzgrpc.Subchannel{
+		Ref:  &channelzgrpc.SubchannelRef{SubchannelId: 3},
+		Data: &channelzgrpc.ChannelData{Target: "10.0.0.1:443", CallsStarted: 5},
+	}}, nil
+}
+
+func (s *nestedChannelServer) GetSocket(ctx context.Context, req *channelzgrpc.GetSocketRequest) (*channelzgrpc.GetSocketResponse, error) {
+	return &channelzgrpc.GetSocketResponse{Socket: &channelzgrpc.Socket{
+		Ref:  &channelzgrpc.SocketRef{SocketId: req.SocketId},
+		Data: &channelzgrpc.SocketData{StreamsStarted: req.SocketId},
+	}}, nil
+}
+
+func (s *nestedChannelServer) GetServers(ctx context.Context, req *channelzgrpc.GetServersRequest) (*channelzgrpc.GetServersResponse, error) {
+	return &channelzgrpc.GetServersResponse{End: true}, nil
+}
+
+func TestChannelzCollectorNestedChannels(t *testing.T) {
+	upstream := &nestedChannelServer{}
+	address := grpc.ServeTestServer(t, func(s googlegrpc.ServiceRegistrar) {
+		channelzgrpc.RegisterChannelzServer(s, upstream)
+	})
+
+	collector := NewChannelzCollector(zap.NewNop(), grpc.NewChannelzProxyServer(zap.NewNop()), func() []string {
+		return []string{address}
+	}, 5*time.Second)
+	expected := fmt.Sprintf(`
+# HELP channelz_channel_calls_started_total Number of calls started on the channel
+# TYPE channelz_channel_calls_started_total counter
+channelz_channel_calls_started_total{address="%[1]s",channel_id="1",channel_target="xds:///api",lb_policy=""} 0
+channelz_channel_calls_started_total{address="%[1]s",channel_id="2",channel_target="xds-server:443",lb_policy=""} 4
+# HELP channelz_subchannel_calls_started_total Number of calls started on the subchannel
+# TYPE channelz_subchannel_calls_started_total counter
+channelz_subchannel_calls_started_total{address="%[1]s",channel_id="2",channel_target="xds-server:443",lb_policy="",subchannel_address="10.0.0.1:443",subchannel_id="3"} 5
+# HELP channelz_socket_streams_started_total Number of streams started on the socket
+# TYPE channelz_socket_streams_started_total counter
+channelz_socket_streams_started_total{address="%[1]s",parent_id="1",parent_kind="channel",socket_id="6",socket_name=""} 6
+channelz_socket_streams_started_total{address="%[1]s",parent_id="2",parent_kind="channel",socket_id="4",socket_name=""} 4
+`, address)
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"channelz_channel_calls_started_total", "channelz_subchannel_calls_started_total", "channelz_socket_streams_started_total"))
+	// Top channels are expanded as listed, only the nested channel is fetched
+	assert.Equal(t, int64(1), atomic.LoadInt64(&upstream.getChannelCalls))
+}
@@ -2,10 +2,13 @@ package util
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"runtime/debug"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -26,3 +29,27 @@ func FormatGrpcError(err error) gin.H{
 		"details": st.Details(),
 	}
 }
+
+// HttpStatus returns the http status code matching a grpc error
+func HttpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// SplitList splits a comma separated list, ignoring empty elements
+func SplitList(value string) []string {
+	res := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			res = append(res, element)
+		}
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"context"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/audit"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// auditHostsKey is the gin context key of the hosts queried by a request
+	auditHostsKey = "auditHosts"
+	// upstreamStatsKey is the gin context key of the upstream rpcs sent by a request
+	upstreamStatsKey = "upstreamStats"
+)
+
+// auditEntityParams are the query parameters recorded as entity ids
+var auditEntityParams = []string{"channelId", "subchannelId", "subchannelIds", "socketId", "serverId", "startSocketId"}
+
+// AuditConfig configures the audit log
+type AuditConfig struct {
+	// Logger receives an entry per api call, nil when the audit log is disabled
+	Logger *audit.Logger
+	// SkipRoutes are the paths which are not audited
+	SkipRoutes []string
+}
+
+// auditMiddleware writes an audit entry once the request is handled
+func auditMiddleware(logger *zap.Logger, config AuditConfig) gin.HandlerFunc {
+	skip := make(map[string]bool, len(config.SkipRoutes))
+	for _, route := range config.SkipRoutes {
+		skip[route] = true
+	}
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		stats := &grpc.UpstreamStats{}
+		c.Set(upstreamStatsKey, stats)
+		c.Next()
+
+		entry := audit.Entry{
+			Time:      start,
+			SourceIP:  c.ClientIP(),
+			Method:    c.Request.Method,
+			Route:     c.FullPath(),
+			Targets:   auditHosts(c),
+			Status:    c.Writer.Status(),
+			LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if entry.Route == "" {
+			entry.Route = c.Request.URL.Path
+		}
+		if principal := getPrincipal(c); principal != nil {
+			entry.Principal = principal.Subject
+			entry.AuthMethod = principal.Method
+		}
+		for _, param := range auditEntityParams {
+			if value, ok := c.GetQuery(param); ok {
+				if entry.EntityIds == nil {
+					entry.EntityIds = make(map[string]string)
+				}
+				entry.EntityIds[param] = value
+			}
+		}
+		rpcs, upstreamLatency := stats.Get()
+		entry.UpstreamRpcs = rpcs
+		entry.UpstreamLatencyMs = float64(upstreamLatency.Microseconds()) / 1000
+		if err := config.Logger.Log(entry); err != nil {
+			logger.Error("Error writing audit entry", zap.Error(err))
+		}
+	}
+}
+
+// setAuditHosts records the hosts queried by the request
+func setAuditHosts(c *gin.Context, hosts []string) {
+	c.Set(auditHostsKey, hosts)
+}
+
+// auditHosts returns the hosts recorded by the handler, or the host and target parameters
+// when the request was rejected before
+func auditHosts(c *gin.Context) []string {
+	if hosts, ok := c.Get(auditHostsKey); ok {
+		return hosts.([]string)
+	}
+	return append(c.QueryArray("host"), c.QueryArray("target")...)
+}
+
+// upstreamContext returns a background context accounting its upstream rpcs to the request audit entry
+func upstreamContext(c *gin.Context) context.Context {
+	if stats, ok := c.Get(upstreamStatsKey); ok {
+		return grpc.WithUpstreamStats(context.Background(), stats.(*grpc.UpstreamStats))
+	}
+	return context.Background()
+}
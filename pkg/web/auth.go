@@ -0,0 +1,39 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// principalKey is the gin context key of the authenticated principal
+const principalKey = "principal"
+
+// authMiddleware rejects requests without valid credentials and stores the principal in the context
+func authMiddleware(logger *zap.Logger, authenticator auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticator.Authenticate(c.Request)
+		if err != nil {
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				logger.Info("Authentication failed", zap.String("client", c.ClientIP()), zap.Error(err))
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="channelz-proxy"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// getPrincipal returns the authenticated principal, nil when authentication is disabled
+func getPrincipal(c *gin.Context) *auth.Principal {
+	principal, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	return principal.(*auth.Principal)
+}
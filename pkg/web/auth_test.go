@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticatedRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("- token: s3cret\n  subject: ci\n"), 0600))
+	authenticator, err := auth.LoadTokensFile(path)
+	require.NoError(t, err)
+	router := testRouter(t, nil, ServerConfig{Authenticator: authenticator})
+
+	assert.Equal(t, http.StatusOK, doRequest(router, http.MethodGet, "/readiness", nil).Code)
+
+	authorized := map[string]string{"Authorization": "Bearer s3cret"}
+	for _, path := range []string{"/metrics", "/probe?target=localhost:1", "/ui/", "/api/channels?host=localhost:1"} {
+		res := doRequest(router, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusUnauthorized, res.Code, path)
+		assert.Equal(t, `Bearer realm="channelz-proxy"`, res.Header().Get("WWW-Authenticate"), path)
+
+		res = doRequest(router, http.MethodGet, path, map[string]string{"Authorization": "Bearer other"})
+		assert.Equal(t, http.StatusUnauthorized, res.Code, path)
+
+		res = doRequest(router, http.MethodGet, path, authorized)
+		assert.NotEqual(t, http.StatusUnauthorized, res.Code, path)
+	}
+}
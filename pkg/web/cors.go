@@ -0,0 +1,175 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CorsConfig is the cross-origin policy of the http server.
+// Origins are exact, like https://dashboard.example.com, wildcard subdomains, like https://*.example.com,
+// or * for any origin. Cross-origin requests are rejected when no origin is allowed.
+type CorsConfig struct {
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	AllowedMethods   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Validate checks the consistency of the policy
+func (cfg CorsConfig) Validate() error {
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			if cfg.AllowCredentials {
+				return errors.New("credentials can't be allowed with a wildcard origin")
+			}
+			continue
+		}
+		parsed, err := url.Parse(strings.Replace(origin, "*.", "", 1))
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" {
+			return errors.New("invalid origin " + origin + ", expected scheme://host[:port]")
+		}
+	}
+	return nil
+}
+
+func (cfg CorsConfig) originAllowed(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// https://*.example.com matches https://a.example.com and https://a.b.example.com, not https://example.com
+		scheme, suffix, isWildcard := strings.Cut(allowed, "://*.")
+		if !isWildcard {
+			continue
+		}
+		prefix, suffix := strings.ToLower(scheme)+"://", "."+strings.ToLower(suffix)
+		origin := strings.ToLower(origin)
+		// The subdomain can't be empty
+		if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, element := range values {
+		if strings.EqualFold(element, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses the addresses and CIDRs of trusted proxies
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy " + proxy)
+			}
+			bits := 8 * len(ip)
+			if ipv4 := ip.To4(); ipv4 != nil {
+				ip, bits = ipv4, 32
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+// requestScheme returns the scheme the request was sent with, from X-Forwarded-Proto when a trusted proxy sets it
+func requestScheme(c *gin.Context, trustedProxies []*net.IPNet) string {
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		if ip := net.ParseIP(c.RemoteIP()); ip != nil {
+			for _, trusted := range trustedProxies {
+				if trusted.Contains(ip) {
+					scheme, _, _ := strings.Cut(proto, ",")
+					return strings.ToLower(strings.TrimSpace(scheme))
+				}
+			}
+		}
+	}
+	return "http"
+}
+
+// isSameOrigin returns true when the origin is the scheme and host the request was sent to
+func isSameOrigin(c *gin.Context, origin string, trustedProxies []*net.IPNet) bool {
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Scheme, requestScheme(c, trustedProxies)) &&
+		strings.EqualFold(parsed.Host, c.Request.Host)
+}
+
+func corsMiddleware(cfg CorsConfig, trustedProxies []*net.IPNet) gin.HandlerFunc {
+	allowedMethods := strings.Join(cfg.AllowedMethods, ", ")
+	allowedHeaders := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	wildcard := containsFold(cfg.AllowedOrigins, "*")
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || isSameOrigin(c, origin, trustedProxies) {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !cfg.originAllowed(origin) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Origin not allowed"})
+			return
+		}
+		if wildcard {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		requestMethod := c.GetHeader("Access-Control-Request-Method")
+		if c.Request.Method != http.MethodOptions || requestMethod == "" {
+			c.Next()
+			return
+		}
+		// Preflight request
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !containsFold(cfg.AllowedMethods, requestMethod) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Method not allowed: " + requestMethod})
+			return
+		}
+		for _, header := range strings.Split(c.GetHeader("Access-Control-Request-Headers"), ",") {
+			header = strings.TrimSpace(header)
+			if header != "" && !containsFold(cfg.AllowedHeaders, header) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Header not allowed: " + header})
+				return
+			}
+		}
+		c.Header("Access-Control-Allow-Methods", allowedMethods)
+		if allowedHeaders != "" {
+			c.Header("Access-Control-Allow-Headers", allowedHeaders)
+		}
+		if cfg.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
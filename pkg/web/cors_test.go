@@ -0,0 +1,131 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCorsConfig() CorsConfig {
+	return CorsConfig{
+		AllowedOrigins: []string{"https://dashboard.example.org", "https://*.example.com"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+		MaxAge:         time.Hour,
+	}
+}
+
+func TestCorsOriginAllowed(t *testing.T) {
+	cfg := testCorsConfig()
+	for origin, expected := range map[string]bool{
+		"https://dashboard.example.org":  true,
+		"HTTPS://Dashboard.Example.org":  true,
+		"http://dashboard.example.org":   false,
+		"https://dashboard.example.org.": false,
+		"https://a.example.com":          true,
+		"https://a.b.example.com":        true,
+		"https://example.com":            false,
+		"https://evilexample.com":        false,
+		"https://.example.com":           false,
+		"https://example.com.evil.org":   false,
+		"http://a.example.com":           false,
+	} {
+		assert.Equal(t, expected, cfg.originAllowed(origin), origin)
+	}
+	assert.True(t, CorsConfig{AllowedOrigins: []string{"*"}}.originAllowed("https://any.org"))
+	assert.False(t, CorsConfig{}.originAllowed("https://any.org"))
+}
+
+func TestCorsValidate(t *testing.T) {
+	assert.NoError(t, testCorsConfig().Validate())
+	assert.NoError(t, CorsConfig{AllowedOrigins: []string{"*"}}.Validate())
+	assert.Error(t, CorsConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Validate())
+	for _, origin := range []string{"example.com", "https://example.com/path", "https://"} {
+		assert.Error(t, CorsConfig{AllowedOrigins: []string{origin}}.Validate(), origin)
+	}
+}
+
+func TestCorsMiddleware(t *testing.T) {
+	cfg := testCorsConfig()
+	cfg.AllowCredentials = true
+	router := testRouter(t, nil, ServerConfig{Cors: cfg})
+
+	res := doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "https://a.example.com"})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "https://a.example.com", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, []string{"Origin"}, res.Header().Values("Vary"))
+
+	// Same origin and requests without origin are not cross-origin
+	res = doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "http://example.com"})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("Access-Control-Allow-Origin"))
+	res = doRequest(router, http.MethodGet, "/readiness", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Values("Vary"))
+
+	res = doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "https://evilexample.com"})
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Empty(t, res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, []string{"Origin"}, res.Header().Values("Vary"))
+}
+
+func TestCorsPreflight(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{Cors: testCorsConfig()})
+	preflight := func(method string, headers string) map[string]string {
+		return map[string]string{
+			"Origin":                         "https://dashboard.example.org",
+			"Access-Control-Request-Method":  method,
+			"Access-Control-Request-Headers": headers,
+		}
+	}
+	vary := []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}
+
+	res := doRequest(router, http.MethodOptions, "/api/channels", preflight(http.MethodPost, "authorization, content-type"))
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Equal(t, "https://dashboard.example.org", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", res.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type", res.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", res.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, res.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, vary, res.Header().Values("Vary"))
+
+	res = doRequest(router, http.MethodOptions, "/api/channels", preflight(http.MethodDelete, ""))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Contains(t, res.Body.String(), "Method not allowed: DELETE")
+	assert.Empty(t, res.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, vary, res.Header().Values("Vary"))
+
+	res = doRequest(router, http.MethodOptions, "/api/channels", preflight(http.MethodGet, "Authorization, X-Custom"))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Contains(t, res.Body.String(), "Header not allowed: X-Custom")
+	assert.Empty(t, res.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestCorsSameOriginScheme(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{Cors: testCorsConfig()})
+	// The request is sent over http, an https origin on the same host is another origin
+	res := doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "https://example.com"})
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	// X-Forwarded-Proto is ignored without trusted proxies
+	res = doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "https://example.com", "X-Forwarded-Proto": "https"})
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	router = testRouter(t, nil, ServerConfig{Cors: testCorsConfig(), TrustedProxies: []string{"192.0.2.0/24"}})
+	res = doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "https://example.com", "X-Forwarded-Proto": "https"})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get("Access-Control-Allow-Origin"))
+	res = doRequest(router, http.MethodGet, "/readiness", map[string]string{"Origin": "http://example.com", "X-Forwarded-Proto": "https"})
+	assert.Equal(t, http.StatusForbidden, res.Code)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.1", "192.0.2.0/24", "::1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1/32", "192.0.2.0/24", "::1/128"}, []string{proxies[0].String(), proxies[1].String(), proxies[2].String()})
+
+	_, err = parseTrustedProxies([]string{"proxy.local"})
+	assert.Error(t, err)
+}
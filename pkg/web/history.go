@@ -0,0 +1,245 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/history"
+	"github.com/gin-gonic/gin"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// historyPoint is the state of an entity in a stored snapshot
+type historyPoint struct {
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// parseTime accepts RFC3339 times, integers as unix timestamps in seconds and unsigned durations before now,
+// like 1h for one hour ago. 0 is now, like the 0s duration, rather than the epoch.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		return time.Time{}, fmt.Errorf("invalid time %q, durations are unsigned and count back from now", value)
+	}
+	if value == "0" {
+		return now, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339, unix seconds or a duration", value)
+	}
+	return now.Add(-duration), nil
+}
+
+// historyHost returns the host snapshots of a queried host are stored under.
+// Registered targets are collected by name, so an address of a single target is mapped back to its name.
+func (s *ChannelzProxyRoutes) historyHost(host string) string {
+	if s.config.Registry == nil {
+		return host
+	}
+	if _, ok := s.config.Registry.Get(host); ok {
+		return host
+	}
+	address, err := grpc.CanonicalAddress(host)
+	if err != nil {
+		return host
+	}
+	if targets := s.config.Registry.LookupAddress(address); len(targets) == 1 {
+		return targets[0].Name
+	}
+	return host
+}
+
+// getTimeQuery parses an optional time parameter and replies with a bad request on error
+func (s *ChannelzProxyRoutes) getTimeQuery(c *gin.Context, name string, defaultValue string) (time.Time, error) {
+	t, err := parseTime(c.DefaultQuery(name, defaultValue), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": name + " should be a time",
+			"details": err.Error()})
+	}
+	return t, err
+}
+
+// parseEntity parses an entity reference like channel:12
+func parseEntity(value string) (string, int64, error) {
+	kind, idStr, found := strings.Cut(value, ":")
+	if !found {
+		return "", 0, fmt.Errorf("entity should be kind:id")
+	}
+	switch kind {
+	case "channel", "subchannel", "server", "socket":
+	default:
+		return "", 0, fmt.Errorf("unknown entity kind %q", kind)
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("entity id should be an int")
+	}
+	return kind, id, nil
+}
+
+func (s *ChannelzProxyRoutes) checkHistoryEnabled(c *gin.Context) bool {
+	if s.config.History == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "History is not enabled"})
+		return false
+	}
+	return true
+}
+
+// historyRoute returns the time series of an entity from the stored snapshots
+func (s *ChannelzProxyRoutes) historyRoute(c *gin.Context) {
+	if !s.checkHistoryEnabled(c) {
+		return
+	}
+	host, err := s.getHost(c)
+	if err != nil {
+		return
+	}
+	kind, id, err := parseEntity(c.Query("entity"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid entity parameter",
+			"details": err.Error()})
+		return
+	}
+	from, err := s.getTimeQuery(c, "from", "1h")
+	if err != nil {
+		return
+	}
+	to, err := s.getTimeQuery(c, "to", "0s")
+	if err != nil {
+		return
+	}
+
+	snapshots, err := s.config.History.Range(s.historyHost(host), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error reading history",
+			"details": err.Error()})
+		return
+	}
+	points := make([]historyPoint, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		var data interface{}
+		switch entity := snapshot.Entity(kind, id).(type) {
+		case nil:
+			continue
+		case *channelzgrpc.Socket:
+			data = grpc.SocketResult{Socket: entity}
+		default:
+			data = entity
+		}
+		points = append(points, historyPoint{Time: snapshot.Time, Data: data})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": points})
+}
+
+// storedSnapshot returns the stored snapshot of host at the time parameter and replies with an error if there is none
+func (s *ChannelzProxyRoutes) storedSnapshot(c *gin.Context, host string, name string) (*history.Snapshot, error) {
+	t, err := s.getTimeQuery(c, name, "")
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := s.config.History.At(s.historyHost(host), t)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error reading history",
+			"details": err.Error()})
+		return nil, err
+	}
+	if snapshot == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("No snapshot of host stored before %s", name)})
+		return nil, fmt.Errorf("no snapshot before %s", t)
+	}
+	return snapshot, nil
+}
+
+// diffRoute compares two stored snapshots of a host, or a stored snapshot with the live state when to is omitted
+func (s *ChannelzProxyRoutes) diffRoute(c *gin.Context) {
+	if !s.checkHistoryEnabled(c) {
+		return
+	}
+	host, err := s.getHost(c)
+	if err != nil {
+		return
+	}
+	if _, ok := c.GetQuery("from"); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing from parameter"})
+		return
+	}
+	from, err := s.storedSnapshot(c, host, "from")
+	if err != nil {
+		return
+	}
+
+	var to *history.Snapshot
+	if toQuery := c.Query("to"); toQuery == "" || toQuery == "live" {
+		ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*20))
+		defer cancel()
+		to, err = history.TakeSnapshot(ctx, s.c, host)
+		if err != nil {
+			s.replyError(c, err)
+			return
+		}
+	} else {
+		to, err = s.storedSnapshot(c, host, "to")
+		if err != nil {
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": history.Compare(from, to)})
+}
+
+// timelineRoute rebuilds the connectivity states of a channel and of its subchannels from their traces,
+// completed with the polled states since from when history is enabled
+func (s *ChannelzProxyRoutes) timelineRoute(c *gin.Context) {
+	if _, ok := c.GetQuery("channelId"); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing channelId parameter"})
+		return
+	}
+	channelId, err := s.getIntQuery(c, "channelId", "0")
+	if err != nil {
+		return
+	}
+	from, err := s.getTimeQuery(c, "from", "1h")
+	if err != nil {
+		return
+	}
+
+	s.runQuery(c, time.Second*20, func(ctx context.Context, host string) (gin.H, error) {
+		var snapshots []*history.Snapshot
+		if s.config.History != nil {
+			var err error
+			snapshots, err = s.config.History.Range(s.historyHost(host), from, time.Now())
+			if err != nil {
+				return nil, err
+			}
+		}
+		channel, err := s.c.GetChannel(ctx, host, channelId)
+		if err != nil {
+			return nil, err
+		}
+		subchannelIds := make([]int64, 0, len(channel.SubchannelRef))
+		for _, subchannelRef := range channel.SubchannelRef {
+			subchannelIds = append(subchannelIds, subchannelRef.SubchannelId)
+		}
+		subchannels, entityErrors, err := s.c.GetSubchannels(ctx, host, subchannelIds)
+		if err != nil {
+			return nil, err
+		}
+		timeline := history.BuildTimeline(channel.Channel, subchannels, snapshots, time.Now())
+		return gin.H{"data": timeline, "errors": entityErrors}, nil
+	})
+}
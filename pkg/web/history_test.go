@@ -0,0 +1,64 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)
+	for value, expected := range map[string]time.Time{
+		"0":                    now,
+		"0s":                   now,
+		"1h":                   now.Add(-time.Hour),
+		"90m":                  now.Add(-90 * time.Minute),
+		"1672653600":           time.Unix(1672653600, 0),
+		"2023-01-02T09:00:00Z": now.Add(-time.Hour),
+	} {
+		parsed, err := parseTime(value, now)
+		require.NoError(t, err, value)
+		assert.True(t, expected.Equal(parsed), "%s: %s", value, parsed)
+	}
+
+	// Durations count back from now, a sign is ambiguous
+	for _, value := range []string{"yesterday", "-1h", "+1h", "-0", "-1672653600"} {
+		_, err := parseTime(value, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestHistoryTargetAddress(t *testing.T) {
+	store, err := history.OpenStore(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	channel := &channelzgrpc.Channel{Ref: &channelzgrpc.ChannelRef{ChannelId: 1}, Data: &channelzgrpc.ChannelData{CallsStarted: 3}}
+	require.NoError(t, store.Put(&history.Snapshot{Host: "api-prod", Time: time.Now().Add(-time.Minute), Channels: []*channelzgrpc.Channel{channel}}))
+
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("targets:\n  - name: api-prod\n    address: 10.0.0.1:9000\n"), 0600))
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	registry := discovery.NewRegistry(zap.NewNop(), c, path)
+	require.NoError(t, registry.Reload())
+	router := testRouter(t, c, ServerConfig{Registry: registry, History: store})
+
+	// Snapshots of a registered target are found by its name and its address
+	for _, query := range []string{"target=api-prod", "host=api-prod", "host=10.0.0.1:9000", "host=dns:///10.0.0.1:9000"} {
+		res := doRequest(router, http.MethodGet, "/api/history?entity=channel:1&"+query, nil)
+		require.Equal(t, http.StatusOK, res.Code, query)
+		assert.Len(t, decodeBody(t, res)["data"], 1, query)
+	}
+	res := doRequest(router, http.MethodGet, "/api/history?entity=channel:1&host=10.0.0.2:9000", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, decodeBody(t, res)["data"])
+}
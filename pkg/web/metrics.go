@@ -0,0 +1,42 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeRoute exports the channelz metrics of a single target, in the style of blackbox_exporter
+func (s *ChannelzProxyRoutes) probeRoute(c *gin.Context) {
+	target, hasTarget := c.GetQuery("target")
+	if !hasTarget {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing target parameter"})
+		return
+	}
+	setAuditHosts(c, []string{target})
+	if err := s.checkHostAccess(c, target); err != nil {
+		return
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics.NewChannelzCollector(s.logger, s.c, func() []string {
+		return []string{target}
+	}, s.config.ScrapeTimeout))
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
+
+// metricsHandler exports the proxy metrics and the channelz metrics of the configured targets
+// the caller may access
+func (s *ChannelzProxyRoutes) metricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		targets := s.allowedHosts(c, s.config.MetricsTargets)
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(metrics.NewChannelzCollector(s.logger, s.c, func() []string {
+			return targets
+		}, s.config.ScrapeTimeout))
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+	}
+}
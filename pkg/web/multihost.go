@@ -0,0 +1,146 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/util"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/status"
+)
+
+// hostQuery is the upstream call of a route for a single host.
+// It returns the json payload sent back to the client.
+type hostQuery func(ctx context.Context, host string) (gin.H, error)
+
+// routeError is an upstream error of a route replying with a message and the error details to single host
+// queries. Its grpc status is the one of the upstream error.
+type routeError struct {
+	message string
+	err     error
+}
+
+func (e routeError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e routeError) Unwrap() error {
+	return e.err
+}
+
+func (e routeError) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+type multiHostRequest struct {
+	Hosts   []string `json:"hosts"`
+	Targets []string `json:"targets"`
+}
+
+// hostResult is the result of a query for one host of a multi-host request
+type hostResult struct {
+	Result    gin.H `json:"result,omitempty"`
+	Error     gin.H `json:"error,omitempty"`
+	LatencyMs int64 `json:"latencyMs"`
+}
+
+func isMultiHost(c *gin.Context) bool {
+	return c.Request.Method == http.MethodPost || len(c.QueryArray("host"))+len(c.QueryArray("target")) > 1
+}
+
+// getHosts returns the hosts from the repeated host and target parameters and the hosts and targets of a POST body
+func (s *ChannelzProxyRoutes) getHosts(c *gin.Context) ([]string, error) {
+	hosts := c.QueryArray("host")
+	targets := c.QueryArray("target")
+	if c.Request.Method == http.MethodPost && c.Request.ContentLength != 0 {
+		var body multiHostRequest
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid body",
+				"details": err.Error()})
+			return nil, err
+		}
+		hosts = append(hosts, body.Hosts...)
+		targets = append(targets, body.Targets...)
+	}
+	if err := s.checkTargets(c, targets); err != nil {
+		return nil, err
+	}
+	hosts = append(hosts, targets...)
+	seen := make(map[string]bool)
+	res := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		if _, err := s.describeHost(host); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid host",
+				"details": err.Error()})
+			return nil, err
+		}
+		res = append(res, host)
+	}
+	if len(res) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing host or target parameter"})
+		return nil, errors.New("Missing host parameter")
+	}
+	setAuditHosts(c, res)
+	return res, nil
+}
+
+// runQuery runs the query against the requested hosts.
+// A single host replies with the query result. Multiple hosts are queried concurrently,
+// each with its own timeout, and results are keyed by host.
+func (s *ChannelzProxyRoutes) runQuery(c *gin.Context, timeout time.Duration, query hostQuery) {
+	hosts, err := s.getHosts(c)
+	if err != nil {
+		return
+	}
+	if !isMultiHost(c) {
+		if err := s.checkHostAccess(c, hosts[0]); err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(hosts[0], timeout))
+		defer cancel()
+		res, err := query(ctx, hosts[0])
+		if err != nil {
+			s.replyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, res)
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]hostResult, len(hosts))
+	for _, host := range hosts {
+		if decision := s.authorizeHost(c, host); !decision.Allowed {
+			results[host] = hostResult{Error: deniedError(decision)}
+			continue
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, timeout))
+			defer cancel()
+			start := time.Now()
+			res, err := query(ctx, host)
+			result := hostResult{Result: res, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Result = nil
+				result.Error = util.FormatGrpcError(err)
+			}
+			mu.Lock()
+			results[host] = result
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+// createTestEmptyServer starts a grpc server without channelz service, every channelz rpc is unimplemented
+func createTestEmptyServer(t *testing.T) string {
+	return grpc.ServeTestServer(t, nil)
+}
+
+func decodeBody(t *testing.T, res *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body), res.Body.String())
+	return body
+}
+
+func TestSingleHostErrorMessages(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{})
+	host := createTestEmptyServer(t)
+	for route, message := range map[string]string{
+		"/api/socket?socketId=1":         "Error getting channels",
+		"/api/servers?":                  "Error getting servers",
+		"/api/serverSockets?serverId=1":  "Error getting server sockets",
+		"/api/subchannel?subchannelId=1": "Error getting subchannel",
+	} {
+		res := doRequest(router, http.MethodGet, route+"&host="+host, nil)
+		assert.Equal(t, http.StatusInternalServerError, res.Code, route)
+		body := decodeBody(t, res)
+		assert.Equal(t, message, body["message"], route)
+		assert.Contains(t, body["details"], "code = Unimplemented", route)
+	}
+}
+
+func TestMultiHostQuery(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{})
+	channelzHost := createTestChannelzServer(t)
+	emptyHost := createTestEmptyServer(t)
+
+	res := doRequest(router, http.MethodGet, "/api/socket?socketId=1&host="+channelzHost+"&host="+emptyHost, nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	data := decodeBody(t, res)["data"].(map[string]interface{})
+	require.Len(t, data, 2)
+	for _, host := range []string{channelzHost, emptyHost} {
+		result := data[host].(map[string]interface{})
+		assert.Nil(t, result["result"], host)
+		assert.Contains(t, result, "latencyMs", host)
+	}
+	// Route errors keep the grpc status of the upstream error
+	assert.Equal(t, float64(codes.NotFound), data[channelzHost].(map[string]interface{})["error"].(map[string]interface{})["code"])
+	assert.Equal(t, float64(codes.Unimplemented), data[emptyHost].(map[string]interface{})["error"].(map[string]interface{})["code"])
+
+	req := httptest.NewRequest(http.MethodPost, "/api/channels", bytes.NewBufferString(`{"hosts": ["`+channelzHost+`"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	data = decodeBody(t, res)["data"].(map[string]interface{})
+	require.Contains(t, data, channelzHost)
+	assert.NotNil(t, data[channelzHost].(map[string]interface{})["result"])
+}
+
+func TestChannelzRoutesAcceptPost(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{})
+	methods := make(map[string][]string)
+	for _, route := range router.Routes() {
+		if strings.HasPrefix(route.Path, "/api/") {
+			methods[route.Path] = append(methods[route.Path], route.Method)
+		}
+	}
+	for _, path := range []string{"/api/channel", "/api/channelSubchannels", "/api/channelTree", "/api/subchannel",
+		"/api/subchannels", "/api/socket", "/api/channels", "/api/servers", "/api/serverSockets", "/api/certificates"} {
+		assert.ElementsMatch(t, []string{http.MethodGet, http.MethodPost}, methods[path], path)
+	}
+}
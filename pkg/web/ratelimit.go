@@ -0,0 +1,111 @@
+package web
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/util"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// clientLimiterIdleTTL is the duration after which the bucket of an inactive client is dropped
+const clientLimiterIdleTTL = 10 * time.Minute
+
+// ClientLimits is the token bucket applied to every api client
+type ClientLimits struct {
+	// Rate is the number of requests per second of a client, 0 disables the limit
+	Rate  float64
+	Burst int
+}
+
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// clientLimiter holds a token bucket per client
+type clientLimiter struct {
+	limits ClientLimits
+
+	mu        sync.Mutex
+	buckets   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+func newClientLimiter(limits ClientLimits) *clientLimiter {
+	if limits.Burst <= 0 {
+		limits.Burst = 1
+	}
+	return &clientLimiter{
+		limits:    limits,
+		buckets:   make(map[string]*clientBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// reserve takes a token of the client and returns the delay before the next one if none is available
+func (l *clientLimiter) reserve(client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > clientLimiterIdleTTL {
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.lastSeen) > clientLimiterIdleTTL {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(rate.Limit(l.limits.Rate), l.limits.Burst)}
+		l.buckets[client] = bucket
+	}
+	bucket.lastSeen = now
+	reservation := bucket.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// setRetryAfter sets the Retry-After header, rounded up to the next second
+func setRetryAfter(c *gin.Context, delay time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+}
+
+// rateLimitMiddleware limits the requests of each client, identified by its ip.
+// It runs before authentication, so requests failing it are limited too.
+func rateLimitMiddleware(limits ClientLimits) gin.HandlerFunc {
+	limiter := newClientLimiter(limits)
+	return func(c *gin.Context) {
+		if delay := limiter.reserve(c.ClientIP()); delay > 0 {
+			setRetryAfter(c, delay)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// replyError replies with the status matching a grpc error, with a Retry-After when the target limits rejected it
+func (s *ChannelzProxyRoutes) replyError(c *gin.Context, err error) {
+	if retryAfter := grpc.RetryAfter(err); retryAfter > 0 {
+		setRetryAfter(c, retryAfter)
+	}
+	var routeErr routeError
+	if errors.As(err, &routeErr) {
+		c.JSON(util.HttpStatus(err), gin.H{
+			"message": routeErr.message,
+			"details": routeErr.err.Error()})
+		return
+	}
+	c.JSON(util.HttpStatus(err), util.FormatGrpcError(err))
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{ClientLimits: ClientLimits{Rate: 0.01, Burst: 1}})
+
+	res := doRequest(router, http.MethodGet, "/api/targets", map[string]string{"X-Forwarded-For": "10.0.0.1"})
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// X-Forwarded-For is ignored without trusted proxies
+	res = doRequest(router, http.MethodGet, "/api/targets", map[string]string{"X-Forwarded-For": "10.0.0.2"})
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+
+	// Readiness is not limited
+	assert.Equal(t, http.StatusOK, doRequest(router, http.MethodGet, "/readiness", nil).Code)
+}
+
+func TestClientRateLimitTrustedProxies(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{ClientLimits: ClientLimits{Rate: 0.01, Burst: 1}, TrustedProxies: []string{"192.0.2.0/24"}})
+
+	res := doRequest(router, http.MethodGet, "/api/targets", map[string]string{"X-Forwarded-For": "10.0.0.1"})
+	assert.Equal(t, http.StatusOK, res.Code)
+	res = doRequest(router, http.MethodGet, "/api/targets", map[string]string{"X-Forwarded-For": "10.0.0.2"})
+	assert.Equal(t, http.StatusOK, res.Code)
+	res = doRequest(router, http.MethodGet, "/api/targets", map[string]string{"X-Forwarded-For": "10.0.0.1"})
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+}
+
+func TestTargetRateLimit(t *testing.T) {
+	address := createTestChannelzServer(t)
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	c.SetTargetLimits(address, grpc.TargetLimits{Rate: 0.01, Burst: 1})
+	router := testRouter(t, c, ServerConfig{})
+
+	res := doRequest(router, http.MethodGet, "/api/channels?host="+address, nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	res = doRequest(router, http.MethodGet, "/api/channels?host="+address, nil)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
+}
+
+func TestClientRateLimitFailedAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("- token: s3cret\n  subject: ci\n"), 0600))
+	authenticator, err := auth.LoadTokensFile(path)
+	require.NoError(t, err)
+	router := testRouter(t, nil, ServerConfig{Authenticator: authenticator, ClientLimits: ClientLimits{Rate: 0.01, Burst: 2}})
+
+	// Failed authentications take tokens of the client ip
+	guess := map[string]string{"Authorization": "Bearer guess"}
+	assert.Equal(t, http.StatusUnauthorized, doRequest(router, http.MethodGet, "/api/targets", guess).Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(router, http.MethodGet, "/api/targets", guess).Code)
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(router, http.MethodGet, "/api/targets", guess).Code)
+	res := doRequest(router, http.MethodGet, "/api/targets", map[string]string{"Authorization": "Bearer s3cret"})
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+}
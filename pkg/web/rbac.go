@@ -0,0 +1,157 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+)
+
+// resolveTimeout bounds the resolution of a host name to the addresses of targets and pods
+const resolveTimeout = 2 * time.Second
+
+func targetResource(target discovery.Target) auth.Resource {
+	return auth.Resource{Name: target.Name, Labels: target.Labels, Address: target.Address}
+}
+
+func podResource(pod discovery.PodTarget) auth.Resource {
+	return auth.Resource{Name: pod.Namespace + "/" + pod.Name, Labels: pod.Labels, Address: pod.Address}
+}
+
+// describeHost returns the name, labels and address of a host from the registry or the discovered pods.
+// A raw address is described by every registered target and discovered pod dialed at the same endpoint,
+// whatever its spelling, so rules on their names and labels apply whichever way the target is addressed.
+// A pod name matching pods of several namespaces or an address which is not a host:port is an error.
+func (s *ChannelzProxyRoutes) describeHost(host string) ([]auth.Resource, error) {
+	if s.config.Registry != nil {
+		if target, ok := s.config.Registry.Get(host); ok {
+			return []auth.Resource{targetResource(target)}, nil
+		}
+	}
+	if s.config.Discovery != nil {
+		pod, ok, err := s.config.Discovery.Lookup(host)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return []auth.Resource{podResource(pod)}, nil
+		}
+	}
+	address, err := grpc.CanonicalAddress(host)
+	if err != nil {
+		return nil, err
+	}
+	var res []auth.Resource
+	for _, candidate := range resolveAddress(address) {
+		if s.config.Registry != nil {
+			for _, target := range s.config.Registry.LookupAddress(candidate) {
+				res = append(res, targetResource(target))
+			}
+		}
+		if s.config.Discovery != nil {
+			for _, pod := range s.config.Discovery.LookupAddress(candidate) {
+				res = append(res, podResource(pod))
+			}
+		}
+	}
+	if len(res) == 0 {
+		res = append(res, auth.Resource{Address: address})
+	}
+	return res, nil
+}
+
+// resolveAddress returns a canonical address followed by the canonical ip:port addresses its host name resolves to
+func resolveAddress(address string) []string {
+	res := []string{address}
+	host, port, _ := net.SplitHostPort(address)
+	if _, err := netip.ParseAddr(host); err == nil {
+		return res
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return res
+	}
+	for _, ip := range ips {
+		if canonical, err := grpc.CanonicalAddress(net.JoinHostPort(ip, port)); err == nil {
+			res = append(res, canonical)
+		}
+	}
+	return res
+}
+
+// authorize returns the policy decision for the principal of the request on a resource
+func (s *ChannelzProxyRoutes) authorize(c *gin.Context, resource auth.Resource) auth.Decision {
+	if s.config.Policy == nil {
+		return auth.Decision{Allowed: true}
+	}
+	return s.config.Policy.Authorize(getPrincipal(c), resource)
+}
+
+// authorizeHost returns the policy decision for the principal of the request on a host.
+// The host is denied if any of the resources describing it is denied.
+func (s *ChannelzProxyRoutes) authorizeHost(c *gin.Context, host string) auth.Decision {
+	resources, err := s.describeHost(host)
+	if err != nil {
+		return auth.Decision{Reason: err.Error()}
+	}
+	var res auth.Decision
+	for i, resource := range resources {
+		decision := s.authorize(c, resource)
+		if !decision.Allowed {
+			return decision
+		}
+		if i == 0 {
+			res = decision
+		}
+	}
+	return res
+}
+
+// checkHostAccess replies with a forbidden explaining the decision if the host is denied
+func (s *ChannelzProxyRoutes) checkHostAccess(c *gin.Context, host string) error {
+	if _, err := s.describeHost(host); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid host",
+			"details": err.Error()})
+		return err
+	}
+	decision := s.authorizeHost(c, host)
+	if !decision.Allowed {
+		c.JSON(http.StatusForbidden, deniedError(decision))
+		return errors.New(decision.Reason)
+	}
+	return nil
+}
+
+// deniedError formats a denial like the grpc errors returned by routes
+func deniedError(decision auth.Decision) gin.H {
+	res := gin.H{
+		"code":    codes.PermissionDenied,
+		"message": decision.Reason,
+	}
+	if decision.Rule != "" {
+		res["rule"] = decision.Rule
+	}
+	return res
+}
+
+// allowedHosts filters the hosts the principal of the request may access
+func (s *ChannelzProxyRoutes) allowedHosts(c *gin.Context, hosts []string) []string {
+	res := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if s.authorizeHost(c, host).Allowed {
+			res = append(res, host)
+		}
+	}
+	return res
+}
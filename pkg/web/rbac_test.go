@@ -0,0 +1,138 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const rbacTargetsFile = `
+targets:
+  - name: payments-db
+    address: 10.0.0.2:9000
+    labels:
+      service: db
+      env: prod
+`
+
+func testPolicy(t *testing.T) *auth.Policy {
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Name: "no-prod-db", Effect: "deny", Groups: []string{"payments"}, Selector: "service=db,env=prod"},
+		{Name: "payments", Groups: []string{"payments"}, Addresses: []string{"10.0.0.*"}},
+	})
+	require.NoError(t, err)
+	return policy
+}
+
+func principalContext(principal *auth.Principal) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(principalKey, principal)
+	return c
+}
+
+func TestAuthorizeRawAddress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(rbacTargetsFile), 0600))
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	registry := discovery.NewRegistry(zap.NewNop(), c, path)
+	require.NoError(t, registry.Reload())
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "payments", Labels: map[string]string{"service": "db", "env": "prod"},
+			Annotations: map[string]string{discovery.DefaultPortAnnotation: "9000"}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.3"},
+	}
+	pods := discovery.NewPodDiscovery(zap.NewNop(), fake.NewSimpleClientset(pod), discovery.PodDiscoveryConfig{})
+	require.NoError(t, pods.Refresh(context.Background()))
+
+	routes := NewChannelzProxyRoutes(zap.NewNop(), c, ServerConfig{Registry: registry, Discovery: pods, Policy: testPolicy(t)})
+	alice := principalContext(&auth.Principal{Subject: "alice", Groups: []string{"payments"}})
+
+	// Deny rules on names and labels apply when the target is addressed by its raw address
+	for _, host := range []string{"payments-db", "10.0.0.2:9000", "payments/db-0", "10.0.0.3:9000",
+		"dns:///10.0.0.2:9000", "passthrough:///10.0.0.3:9000", "ipv4:10.0.0.2:9000",
+		"10.0.0.2:09000", "[::ffff:10.0.0.2]:9000", "[::ffff:10.0.0.3]:09000", " 10.0.0.3:9000"} {
+		decision := routes.authorizeHost(alice, host)
+		assert.False(t, decision.Allowed, host)
+		assert.Equal(t, "no-prod-db", decision.Rule, host)
+	}
+	decision := routes.authorizeHost(alice, "10.0.0.4:9000")
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, "payments", decision.Rule)
+}
+
+func TestAmbiguousPodName(t *testing.T) {
+	var objects []runtime.Object
+	for _, namespace := range []string{"payments", "orders"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-0", Namespace: namespace,
+				Annotations: map[string]string{discovery.DefaultPortAnnotation: "9000"}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.3"},
+		})
+	}
+	pods := discovery.NewPodDiscovery(zap.NewNop(), fake.NewSimpleClientset(objects...), discovery.PodDiscoveryConfig{})
+	require.NoError(t, pods.Refresh(context.Background()))
+	router := testRouter(t, nil, ServerConfig{Discovery: pods})
+
+	for _, path := range []string{"/api/channels?host=api-0", "/api/channels?host=api-0&host=orders/api-0"} {
+		res := doRequest(router, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusBadRequest, res.Code, path)
+		assert.Contains(t, res.Body.String(), "use one of orders/api-0, payments/api-0", path)
+	}
+}
+
+const rbacLocalTargetsFile = `
+targets:
+  - name: local-db
+    address: 127.0.0.1:9000
+    labels:
+      service: db
+      env: prod
+`
+
+func TestAuthorizeResolvedAddress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(rbacLocalTargetsFile), 0600))
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	registry := discovery.NewRegistry(zap.NewNop(), c, path)
+	require.NoError(t, registry.Reload())
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Name: "no-prod-db", Effect: "deny", Groups: []string{"payments"}, Selector: "service=db,env=prod"},
+		{Name: "payments", Groups: []string{"payments"}, Addresses: []string{"*"}},
+	})
+	require.NoError(t, err)
+	routes := NewChannelzProxyRoutes(zap.NewNop(), c, ServerConfig{Registry: registry, Policy: policy})
+	alice := principalContext(&auth.Principal{Subject: "alice", Groups: []string{"payments"}})
+
+	// Host names resolving to the target address are mapped back to the target
+	for _, host := range []string{"localhost:9000", "LOCALHOST:9000", "dns:///localhost:9000", "localhost.:09000"} {
+		decision := routes.authorizeHost(alice, host)
+		assert.False(t, decision.Allowed, host)
+		assert.Equal(t, "no-prod-db", decision.Rule, host)
+	}
+	decision := routes.authorizeHost(alice, "localhost:9001")
+	assert.True(t, decision.Allowed)
+
+	router := testRouter(t, c, ServerConfig{Registry: registry, Policy: policy})
+	for _, host := range []string{"unix:///tmp/grpc.sock", "xds:///local-db:9000", "127.0.0.1"} {
+		res := doRequest(router, http.MethodGet, "/api/channels?host="+url.QueryEscape(host), nil)
+		assert.Equal(t, http.StatusBadRequest, res.Code, host)
+		assert.Contains(t, res.Body.String(), "Invalid host", host)
+	}
+}
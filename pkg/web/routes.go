@@ -3,6 +3,7 @@ package web
 import (
 	"context"
 	"errors"
+	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
@@ -18,57 +19,114 @@ import (
 type ChannelzProxyRoutes struct {
 	c      *grpc.ChannelzProxyServer
 	logger *zap.Logger
+	config ServerConfig
+
+	templates map[string]*template.Template
+	watchHub  *watchHub
 }
 
-func NewChannelzProxyRoutes(logger *zap.Logger) *ChannelzProxyRoutes {
+func NewChannelzProxyRoutes(logger *zap.Logger, channelzProxyServer *grpc.ChannelzProxyServer, config ServerConfig) *ChannelzProxyRoutes {
 	return &ChannelzProxyRoutes{
-		c:      grpc.NewChannelzProxyServer(logger),
-		logger: logger,
+		c:         channelzProxyServer,
+		logger:    logger,
+		config:    config,
+		templates: loadUiTemplates(),
+		watchHub:  newWatchHub(logger, channelzProxyServer),
 	}
 }
 
+// getHost returns the host parameter or the name of the target parameter
 func (s *ChannelzProxyRoutes) getHost(c *gin.Context) (string, error) {
 	host, hasHost := c.GetQuery("host")
+	if target, hasTarget := c.GetQuery("target"); hasTarget {
+		if err := s.checkTargets(c, []string{target}); err != nil {
+			return "", err
+		}
+		host, hasHost = target, true
+	}
 	if !hasHost {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing host parameter"})
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing host or target parameter"})
 		return "", errors.New("Missing host parameter")
 	}
+	setAuditHosts(c, []string{host})
+	if err := s.checkHostAccess(c, host); err != nil {
+		return "", err
+	}
 	return host, nil
 }
 
-func (s *ChannelzProxyRoutes) readinessRoute(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "Ok"})
+// getIntQuery parses an optional int query parameter and replies with a bad request on error
+func (s *ChannelzProxyRoutes) getIntQuery(c *gin.Context, name string, defaultValue string) (int64, error) {
+	value, err := strconv.ParseInt(c.DefaultQuery(name, defaultValue), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": name + " should be an int",
+			"details": err.Error()})
+		return 0, err
+	}
+	return value, nil
 }
 
-func (s *ChannelzProxyRoutes) channelRoute(c *gin.Context) {
-	host, err := s.getHost(c)
+// getPagination parses the limit and maxResults parameters of paginated routes
+func (s *ChannelzProxyRoutes) getPagination(c *gin.Context) (int64, int64, error) {
+	limit, err := s.getIntQuery(c, "limit", "0")
 	if err != nil {
-		return
+		return 0, 0, err
 	}
-	channelId, err := strconv.Atoi(c.DefaultQuery("channelId", "0"))
+	maxResults, err := s.getIntQuery(c, "maxResults", "0")
+	if err != nil {
+		return 0, 0, err
+	}
+	return limit, maxResults, nil
+}
+
+// getExpand parses the expand and maxDepth parameters
+func (s *ChannelzProxyRoutes) getExpand(c *gin.Context, defaultExpand string) (grpc.Expand, int, error) {
+	expand, err := grpc.ParseExpand(c.DefaultQuery("expand", defaultExpand))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "channelId should be an int",
+			"message": "expand should be a list of subchannels, sockets and nestedChannels",
 			"details": err.Error()})
-		return
+		return expand, 0, err
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	channel, err := s.c.GetChannel(ctx, host, int64(channelId))
+	maxDepth, err := s.getIntQuery(c, "maxDepth", strconv.Itoa(grpc.DefaultTreeDepth))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, util.FormatGrpcError(err))
-		return
+		return expand, 0, err
 	}
-	c.JSON(http.StatusOK, gin.H{"data": channel})
+	return expand, int(maxDepth), nil
 }
 
-// Get states of all subchannels of a channel
-func (s *ChannelzProxyRoutes) channelSubchannelsRoute(c *gin.Context) {
-	host, err := s.getHost(c)
-	if err != nil {
-		return
+// getTraceEventFilter parses the repeated or comma separated severity and type parameters
+func (s *ChannelzProxyRoutes) getTraceEventFilter(c *gin.Context) (grpc.TraceEventFilter, error) {
+	var filter grpc.TraceEventFilter
+	for _, value := range util.SplitList(strings.Join(c.QueryArray("severity"), ",")) {
+		severity, err := grpc.ParseSeverity(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid severity parameter",
+				"details": err.Error()})
+			return filter, err
+		}
+		filter.Severities = append(filter.Severities, severity)
 	}
+	for _, value := range util.SplitList(strings.Join(c.QueryArray("type"), ",")) {
+		eventType, err := grpc.ParseTraceEventType(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid type parameter",
+				"details": err.Error()})
+			return filter, err
+		}
+		filter.Types = append(filter.Types, eventType)
+	}
+	return filter, nil
+}
+
+func (s *ChannelzProxyRoutes) readinessRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"message": "Ok"})
+}
+
+func (s *ChannelzProxyRoutes) channelRoute(c *gin.Context) {
 	channelId, err := strconv.Atoi(c.DefaultQuery("channelId", "0"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -77,34 +135,83 @@ func (s *ChannelzProxyRoutes) channelSubchannelsRoute(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
-	defer cancel()
-
-	channel, err := s.c.GetChannel(ctx, host, int64(channelId))
+	expand, maxDepth, err := s.getExpand(c, "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, util.FormatGrpcError(err))
 		return
 	}
-
-	subchannelIds := make([]int64, 0)
-	for _, subchannelRef := range channel.SubchannelRef {
-		subchannelIds = append(subchannelIds, subchannelRef.SubchannelId)
+	filter, err := s.getTraceEventFilter(c)
+	if err != nil {
+		return
 	}
 
-	subchannels, err := s.c.GetSubchannels(ctx, host, subchannelIds)
+	s.runQuery(c, time.Second*5, func(ctx context.Context, host string) (gin.H, error) {
+		if !expand.IsEmpty() {
+			channelTree, err := s.c.GetChannelTree(ctx, host, int64(channelId), expand, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			events := grpc.ParseTraceEvents(channelTree.GetData().GetTrace().GetEvents())
+			return gin.H{"data": channelTree, "events": filter.Filter(events)}, nil
+		}
+		channel, err := s.c.GetChannel(ctx, host, int64(channelId))
+		if err != nil {
+			return nil, err
+		}
+		events := grpc.ParseTraceEvents(channel.GetData().GetTrace().GetEvents())
+		return gin.H{"data": channel, "events": filter.Filter(events)}, nil
+	})
+}
+
+// Get a channel with all its nested channels, subchannels and sockets
+func (s *ChannelzProxyRoutes) channelTreeRoute(c *gin.Context) {
+	channelId, err := s.getIntQuery(c, "channelId", "0")
+	if err != nil {
+		return
+	}
+	expand, maxDepth, err := s.getExpand(c, "subchannels,sockets,nestedChannels")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, util.FormatGrpcError(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": subchannels})
+	s.runQuery(c, time.Second*20, func(ctx context.Context, host string) (gin.H, error) {
+		channelTree, err := s.c.GetChannelTree(ctx, host, channelId, expand, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"data": channelTree}, nil
+	})
 }
 
-func (s *ChannelzProxyRoutes) subchannelsRoute(c *gin.Context) {
-	host, err := s.getHost(c)
+// Get states of all subchannels of a channel
+func (s *ChannelzProxyRoutes) channelSubchannelsRoute(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.DefaultQuery("channelId", "0"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "channelId should be an int",
+			"details": err.Error()})
 		return
 	}
+
+	s.runQuery(c, time.Second*20, func(ctx context.Context, host string) (gin.H, error) {
+		channel, err := s.c.GetChannel(ctx, host, int64(channelId))
+		if err != nil {
+			return nil, err
+		}
+
+		subchannelIds := make([]int64, 0)
+		for _, subchannelRef := range channel.SubchannelRef {
+			subchannelIds = append(subchannelIds, subchannelRef.SubchannelId)
+		}
+
+		subchannels, entityErrors, err := s.c.GetSubchannels(ctx, host, subchannelIds)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"data": subchannels, "errors": entityErrors}, nil
+	})
+}
+
+func (s *ChannelzProxyRoutes) subchannelsRoute(c *gin.Context) {
 	subchannelIdsQuery := c.Query("subchannelIds")
 	if subchannelIdsQuery == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing subchannelIds parameter"})
@@ -124,23 +231,16 @@ func (s *ChannelzProxyRoutes) subchannelsRoute(c *gin.Context) {
 		subchannelIds = append(subchannelIds, int64(subchannelId))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
-	defer cancel()
-
-	subchannels, err := s.c.GetSubchannels(ctx, host, subchannelIds)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, util.FormatGrpcError(err))
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"data": subchannels})
+	s.runQuery(c, time.Second*20, func(ctx context.Context, host string) (gin.H, error) {
+		subchannels, entityErrors, err := s.c.GetSubchannels(ctx, host, subchannelIds)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"data": subchannels, "errors": entityErrors}, nil
+	})
 }
 
 func (s *ChannelzProxyRoutes) subchannelRoute(c *gin.Context) {
-	host, err := s.getHost(c)
-	if err != nil {
-		return
-	}
 	channelId, err := strconv.Atoi(c.DefaultQuery("subchannelId", "0"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -149,46 +249,41 @@ func (s *ChannelzProxyRoutes) subchannelRoute(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	subchannel, err := s.c.GetSubchannel(ctx, host, int64(channelId))
+	filter, err := s.getTraceEventFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error getting subchannel",
-			"details": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": subchannel})
+
+	s.runQuery(c, time.Second*5, func(ctx context.Context, host string) (gin.H, error) {
+		subchannel, err := s.c.GetSubchannel(ctx, host, int64(channelId))
+		if err != nil {
+			return nil, routeError{"Error getting subchannel", err}
+		}
+		events := grpc.ParseTraceEvents(subchannel.GetData().GetTrace().GetEvents())
+		return gin.H{"data": subchannel, "events": filter.Filter(events)}, nil
+	})
 }
 
 func (s *ChannelzProxyRoutes) channelsRoute(c *gin.Context) {
-	host, err := s.getHost(c)
+	startId, err := s.getIntQuery(c, "startId", "0")
 	if err != nil {
 		return
 	}
-	startId, err := strconv.Atoi(c.DefaultQuery("startId", "0"))
+	limit, maxResults, err := s.getPagination(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "startId should be an int",
-			"details": err.Error()})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	channels, err := s.c.GetTopChannels(ctx, host, int64(startId))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, util.FormatGrpcError(err))
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"data": channels})
+	s.runQuery(c, time.Second*5, func(ctx context.Context, host string) (gin.H, error) {
+		channels, page, err := s.c.GetTopChannels(ctx, host, startId, limit, maxResults)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"data": channels, "nextStartId": page.NextStartId, "end": page.End}, nil
+	})
 }
 
 func (s *ChannelzProxyRoutes) socketRoute(c *gin.Context) {
-	host, err := s.getHost(c)
-	if err != nil {
-		return
-	}
 	socketId, err := strconv.Atoi(c.DefaultQuery("socketId", "0"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -197,47 +292,46 @@ func (s *ChannelzProxyRoutes) socketRoute(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	channels, err := s.c.GetSocket(ctx, host, int64(socketId))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error getting channels",
-			"details": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"data": channels})
+	s.runQuery(c, time.Second*5, func(ctx context.Context, host string) (gin.H, error) {
+		socket, err := s.c.GetSocket(ctx, host, int64(socketId))
+		if err != nil {
+			return nil, routeError{"Error getting channels", err}
+		}
+		return gin.H{"data": grpc.SocketResult{Socket: socket}}, nil
+	})
 }
 
 func (s *ChannelzProxyRoutes) serversRoute(c *gin.Context) {
-	host, err := s.getHost(c)
+	startId, err := s.getIntQuery(c, "startId", "0")
 	if err != nil {
 		return
 	}
-	startId, err := strconv.Atoi(c.DefaultQuery("startId", "0"))
+	limit, maxResults, err := s.getPagination(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"message": "startId should be an int",
-			"details": err.Error()})
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	servers, err := s.c.GetServers(ctx, host, int64(startId))
+	expand, _, err := s.getExpand(c, "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error getting servers",
-			"details": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": servers})
+
+	s.runQuery(c, time.Second*5, func(ctx context.Context, host string) (gin.H, error) {
+		servers, page, err := s.c.GetServers(ctx, host, startId, limit, maxResults)
+		if err != nil {
+			return nil, routeError{"Error getting servers", err}
+		}
+		if !expand.IsEmpty() {
+			serverNodes, err := s.c.ExpandServers(ctx, host, servers, expand)
+			if err != nil {
+				return nil, err
+			}
+			return gin.H{"data": serverNodes, "nextStartId": page.NextStartId, "end": page.End}, nil
+		}
+		return gin.H{"data": servers, "nextStartId": page.NextStartId, "end": page.End}, nil
+	})
 }
 
 func (s *ChannelzProxyRoutes) serverSocketsRoute(c *gin.Context) {
-	host, err := s.getHost(c)
-	if err != nil {
-		return
-	}
 	serverId, err := strconv.Atoi(c.DefaultQuery("serverId", "0"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -254,14 +348,63 @@ func (s *ChannelzProxyRoutes) serverSocketsRoute(c *gin.Context) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	sockets, err := s.c.GetServerSockets(ctx, host, int64(serverId), int64(startSocketId))
+	s.runQuery(c, time.Second*5, func(ctx context.Context, host string) (gin.H, error) {
+		sockets, entityErrors, err := s.c.GetServerSockets(ctx, host, int64(serverId), int64(startSocketId))
+		if err != nil {
+			return nil, routeError{"Error getting server sockets", err}
+		}
+		return gin.H{"data": grpc.NewSocketResults(sockets), "errors": entityErrors}, nil
+	})
+}
+
+// Get the distinct certificates used by the server, channel and subchannel sockets of a target
+func (s *ChannelzProxyRoutes) certificatesRoute(c *gin.Context) {
+	expiringWithin := s.config.CertificateExpiryWindow
+	if value, ok := c.GetQuery("expiringWithin"); ok {
+		var err error
+		expiringWithin, err = time.ParseDuration(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "expiringWithin should be a duration",
+				"details": err.Error()})
+			return
+		}
+	}
+
+	s.runQuery(c, time.Second*20, func(ctx context.Context, host string) (gin.H, error) {
+		certificates, entityErrors, err := s.c.GetCertificates(ctx, host, expiringWithin)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"data": certificates, "errors": entityErrors}, nil
+	})
+}
+
+func (s *ChannelzProxyRoutes) connectionsRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": s.allowedConnections(c)})
+}
+
+// allowedConnections lists the cached connections to addresses the principal of the request may access
+func (s *ChannelzProxyRoutes) allowedConnections(c *gin.Context) []grpc.ConnectionInfo {
+	connections := s.c.ListConnections()
+	res := make([]grpc.ConnectionInfo, 0, len(connections))
+	for _, connection := range connections {
+		if s.authorizeHost(c, connection.Address).Allowed {
+			res = append(res, connection)
+		}
+	}
+	return res
+}
+
+func (s *ChannelzProxyRoutes) closeConnectionRoute(c *gin.Context) {
+	host, err := s.getHost(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Error getting server sockets",
-			"details": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"data": sockets})
+	closed := s.c.CloseConnections(host)
+	if closed == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "No cached connection for host"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"closed": closed}})
 }
@@ -5,56 +5,128 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/history"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	gintrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/gin-gonic/gin"
 )
 
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	}
+// ServerConfig is the configuration of the http server
+type ServerConfig struct {
+	ListenAddress string
+	// MetricsTargets are the addresses exported on /metrics
+	MetricsTargets []string
+	// ScrapeTimeout is the timeout of a channelz scrape for metrics
+	ScrapeTimeout time.Duration
+	// WatchInterval is the default poll interval of watched entities
+	WatchInterval time.Duration
+	// History is the snapshot store, nil when history is disabled
+	History *history.Store
+	// Discovery lists the kubernetes pods exposing channelz, nil when discovery is disabled
+	Discovery *discovery.PodDiscovery
+	// Registry holds the named targets, nil when no targets file is configured
+	Registry *discovery.Registry
+	// Authenticator identifies clients, nil when authentication is disabled
+	Authenticator auth.Authenticator
+	// Policy authorizes principals on targets, nil when every target is allowed
+	Policy *auth.Policy
+	// Cors is the cross-origin policy
+	Cors CorsConfig
+	// ClientLimits is the rate limit of each api client
+	ClientLimits ClientLimits
+	// Audit is the audit log configuration
+	Audit AuditConfig
+	// CertificateExpiryWindow is the default window flagging expiring certificates
+	CertificateExpiryWindow time.Duration
+	// TrustedProxies are the addresses or CIDRs of proxies allowed to set the client ip with
+	// X-Forwarded-For, the peer address is used if empty
+	TrustedProxies []string
 }
 
-func setupRouter(logger *zap.Logger) *gin.Engine {
+func setupRouter(logger *zap.Logger, channelzProxyServer *grpc.ChannelzProxyServer, config ServerConfig) *gin.Engine {
 	router := gin.Default()
-	router.Use(corsMiddleware())
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
+	router.Use(corsMiddleware(config.Cors, trustedProxies))
 	skipLogs := []string{
 		"/health",
+		"/metrics",
 	}
 	router.Use(gin.LoggerWithWriter(gin.DefaultWriter, skipLogs...))
 	router.Use(gin.Recovery())
+	router.Use(instrumentationMiddleware())
+	if config.Audit.Logger != nil {
+		router.Use(auditMiddleware(logger, config.Audit))
+	}
 	router.Use(gintrace.Middleware("channelz-proxy"))
 
-	c := NewChannelzProxyRoutes(logger)
+	c := NewChannelzProxyRoutes(logger, channelzProxyServer, config)
 	router.GET("/readiness", c.readinessRoute)
+	// Routes registered after this point are rate limited and require authentication.
+	// Requests are limited before authentication so failed attempts are limited too.
+	if config.ClientLimits.Rate > 0 {
+		router.Use(rateLimitMiddleware(config.ClientLimits))
+	}
+	if config.Authenticator != nil {
+		router.Use(authMiddleware(logger, config.Authenticator))
+	}
+	router.GET("/probe", c.probeRoute)
+	router.GET("/metrics", c.metricsHandler())
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Redirect(http.StatusFound, "/ui/")
+	})
+	ui := router.Group("/ui")
+	{
+		ui.GET("/", c.uiHostsRoute)
+		ui.GET("/channels", c.uiChannelsRoute)
+		ui.GET("/channel", c.uiChannelRoute)
+		ui.GET("/subchannel", c.uiSubchannelRoute)
+		ui.GET("/socket", c.uiSocketRoute)
+		ui.GET("/servers", c.uiServersRoute)
+		ui.GET("/server", c.uiServerRoute)
+	}
 	api := router.Group("/api")
 	{
-		api.GET("/channel", c.channelRoute)
-		api.GET("/channelSubchannels", c.channelSubchannelsRoute)
-		api.GET("/subchannel", c.subchannelRoute)
-		api.GET("/subchannels", c.subchannelsRoute)
-		api.GET("/socket", c.socketRoute)
-		api.GET("/channels", c.channelsRoute)
-		api.GET("/servers", c.serversRoute)
-		api.GET("/serverSockets", c.serverSocketsRoute)
+		// Channelz routes accept a POST with a list of hosts in the body for multi-host queries
+		channelzRoutes := map[string]gin.HandlerFunc{
+			"/channel":            c.channelRoute,
+			"/channelSubchannels": c.channelSubchannelsRoute,
+			"/channelTree":        c.channelTreeRoute,
+			"/subchannel":         c.subchannelRoute,
+			"/subchannels":        c.subchannelsRoute,
+			"/socket":             c.socketRoute,
+			"/channels":           c.channelsRoute,
+			"/servers":            c.serversRoute,
+			"/serverSockets":      c.serverSocketsRoute,
+			"/certificates":       c.certificatesRoute,
+		}
+		for path, handler := range channelzRoutes {
+			api.GET(path, handler)
+			api.POST(path, handler)
+		}
+		api.GET("/watch", c.watchRoute)
+		api.GET("/history", c.historyRoute)
+		api.GET("/diff", c.diffRoute)
+		api.GET("/timeline", c.timelineRoute)
+		api.GET("/targets", c.targetsRoute)
+		api.GET("/connections", c.connectionsRoute)
+		api.DELETE("/connections", c.closeConnectionRoute)
 	}
 	return router
 }
 
-func StartServer(ctx context.Context, addr string, logger *zap.Logger) {
-	router := setupRouter(logger)
+func StartServer(ctx context.Context, logger *zap.Logger, channelzProxyServer *grpc.ChannelzProxyServer, config ServerConfig) {
+	router := setupRouter(logger, channelzProxyServer, config)
 	srv := &http.Server{
-		Addr:    addr,
+		Addr:    config.ListenAddress,
 		Handler: router,
 	}
 	go func() {
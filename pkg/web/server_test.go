@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	channelz "google.golang.org/grpc/channelz/service"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// createTestChannelzServer starts a channelz server on a random port and returns its address
+func createTestChannelzServer(t *testing.T) string {
+	return grpc.ServeTestServer(t, channelz.RegisterChannelzServiceToServer)
+}
+
+// testRouter returns the router of a proxy, a proxy without upstream configuration is used if c is nil
+func testRouter(t *testing.T, c *grpc.ChannelzProxyServer, config ServerConfig) *gin.Engine {
+	if c == nil {
+		c = grpc.NewChannelzProxyServer(zap.NewNop())
+	}
+	return setupRouter(zap.NewNop(), c, config)
+}
+
+// doRequest serves a request from the peer 192.0.2.1 with the given headers
+func doRequest(router http.Handler, method string, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/auth"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
+	"github.com/gin-gonic/gin"
+)
+
+// targetsRoute lists the registered and discovered targets the caller may access, usable as host in every route
+func (s *ChannelzProxyRoutes) targetsRoute(c *gin.Context) {
+	registry := make([]discovery.Target, 0)
+	if s.config.Registry != nil {
+		for _, target := range s.config.Registry.Targets() {
+			resource := auth.Resource{Name: target.Name, Labels: target.Labels, Address: target.Address}
+			if s.authorize(c, resource).Allowed {
+				registry = append(registry, target)
+			}
+		}
+	}
+	pods := make([]discovery.PodTarget, 0)
+	if s.config.Discovery != nil {
+		for _, pod := range s.config.Discovery.Targets() {
+			resource := auth.Resource{Name: pod.Namespace + "/" + pod.Name, Labels: pod.Labels, Address: pod.Address}
+			if s.authorize(c, resource).Allowed {
+				pods = append(pods, pod)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"registry": registry, "kubernetes": pods}})
+}
+
+// checkTargets replies with a not found if a target name is not in the registry.
+// Target names are resolved to their address when dialing, like any host.
+func (s *ChannelzProxyRoutes) checkTargets(c *gin.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if s.config.Registry == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "No targets registry configured"})
+		return errors.New("No targets registry configured")
+	}
+	for _, name := range names {
+		if _, ok := s.config.Registry.Get(name); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unknown target " + name})
+			return errors.New("Unknown target " + name)
+		}
+	}
+	return nil
+}
+
+// targetTimeout returns the timeout of upstream calls to a host, defaultTimeout if the registry doesn't override it
+func (s *ChannelzProxyRoutes) targetTimeout(host string, defaultTimeout time.Duration) time.Duration {
+	if s.config.Registry == nil {
+		return defaultTimeout
+	}
+	if target, ok := s.config.Registry.Get(host); ok && target.Timeout > 0 {
+		return target.Timeout
+	}
+	return defaultTimeout
+}
+
+// targetPollInterval returns the poll interval of a host, defaultInterval if the registry doesn't override it
+func (s *ChannelzProxyRoutes) targetPollInterval(host string, defaultInterval time.Duration) time.Duration {
+	if s.config.Registry == nil {
+		return defaultInterval
+	}
+	if target, ok := s.config.Registry.Get(host); ok && target.PollInterval > 0 {
+		return target.PollInterval
+	}
+	return defaultInterval
+}
@@ -0,0 +1,212 @@
+package web
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/util"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var uiPages = []string{"hosts", "channels", "channel", "subchannel", "socket", "servers", "server"}
+
+// uiPage is the data passed to every page template
+type uiPage struct {
+	Host  string
+	Error string
+	Data  interface{}
+}
+
+var uiFuncs = template.FuncMap{
+	"timestamp": func(ts *timestamppb.Timestamp) string {
+		if ts == nil {
+			return ""
+		}
+		return ts.AsTime().Format("2006-01-02 15:04:05.000")
+	},
+	"address": grpc.FormatAddress,
+	"join":    strings.Join,
+	"security": func(security *channelzgrpc.Security) string {
+		if tls := security.GetTls(); tls != nil {
+			name := tls.GetStandardName()
+			if name == "" {
+				name = tls.GetOtherName()
+			}
+			return fmt.Sprintf("TLS %s, local certificate: %d bytes, remote certificate: %d bytes",
+				name, len(tls.GetLocalCertificate()), len(tls.GetRemoteCertificate()))
+		}
+		if other := security.GetOther(); other != nil {
+			return other.GetName()
+		}
+		return "plaintext"
+	},
+	// withHost passes the current host with a value to a nested template
+	"withHost": func(host string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"Host": host, "Value": value}
+	},
+}
+
+func loadUiTemplates() map[string]*template.Template {
+	templates := make(map[string]*template.Template)
+	for _, page := range uiPages {
+		templates[page] = template.Must(template.New(page).Funcs(uiFuncs).
+			ParseFS(templatesFS, "templates/layout.html", "templates/"+page+".html"))
+	}
+	return templates
+}
+
+func (s *ChannelzProxyRoutes) renderPage(c *gin.Context, page string, host string, data interface{}, err error) {
+	pageData := uiPage{Host: host, Data: data}
+	status := http.StatusOK
+	if err != nil {
+		pageData.Error = err.Error()
+		pageData.Data = nil
+		status = util.HttpStatus(err)
+	}
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates[page].ExecuteTemplate(c.Writer, "layout", pageData); err != nil {
+		s.logger.Warn("Error rendering page", zap.String("page", page), zap.Error(err))
+	}
+}
+
+// uiQuery parses the host and an optional int id parameter of a page
+func (s *ChannelzProxyRoutes) uiQuery(c *gin.Context, idName string) (string, int64, bool) {
+	host := c.Query("host")
+	if host == "" {
+		c.Redirect(http.StatusFound, "/ui/")
+		return "", 0, false
+	}
+	setAuditHosts(c, []string{host})
+	if _, err := s.describeHost(host); err != nil {
+		s.renderPage(c, "hosts", host, nil, status.Error(codes.InvalidArgument, err.Error()))
+		return "", 0, false
+	}
+	if decision := s.authorizeHost(c, host); !decision.Allowed {
+		s.renderPage(c, "hosts", host, nil, status.Error(codes.PermissionDenied, decision.Reason))
+		return "", 0, false
+	}
+	if idName == "" {
+		return host, 0, true
+	}
+	id, err := strconv.ParseInt(c.DefaultQuery(idName, "0"), 10, 64)
+	if err != nil {
+		s.renderPage(c, "hosts", host, nil, fmt.Errorf("%s should be an int", idName))
+		return "", 0, false
+	}
+	return host, id, true
+}
+
+func (s *ChannelzProxyRoutes) uiHostsRoute(c *gin.Context) {
+	s.renderPage(c, "hosts", "", s.allowedConnections(c), nil)
+}
+
+func (s *ChannelzProxyRoutes) uiChannelsRoute(c *gin.Context) {
+	host, _, ok := s.uiQuery(c, "")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*5))
+	defer cancel()
+	channels, _, err := s.c.GetTopChannels(ctx, host, 0, 0, 0)
+	s.renderPage(c, "channels", host, channels, err)
+}
+
+func (s *ChannelzProxyRoutes) uiChannelRoute(c *gin.Context) {
+	host, channelId, ok := s.uiQuery(c, "channelId")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*5))
+	defer cancel()
+	expand := grpc.Expand{Subchannels: true, NestedChannels: true}
+	channel, err := s.c.GetChannelTree(ctx, host, channelId, expand, 1)
+	s.renderPage(c, "channel", host, channel, err)
+}
+
+func (s *ChannelzProxyRoutes) uiSubchannelRoute(c *gin.Context) {
+	host, subchannelId, ok := s.uiQuery(c, "subchannelId")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*5))
+	defer cancel()
+	subchannel, err := s.c.GetSubchannel(ctx, host, subchannelId)
+	if err != nil {
+		s.renderPage(c, "subchannel", host, nil, err)
+		return
+	}
+	// The subchannel is rendered even when its sockets could not be fetched
+	sockets, entityErrors, err := s.c.GetSockets(ctx, host, grpc.SocketRefIds(subchannel.SocketRef))
+	data := gin.H{
+		"Ref":     subchannel.Ref,
+		"Data":    subchannel.Data,
+		"Sockets": sockets,
+		"Errors":  entityErrors,
+	}
+	if err != nil && len(entityErrors) == 0 {
+		data["SocketsError"] = err.Error()
+	}
+	s.renderPage(c, "subchannel", host, data, nil)
+}
+
+func (s *ChannelzProxyRoutes) uiSocketRoute(c *gin.Context) {
+	host, socketId, ok := s.uiQuery(c, "socketId")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*5))
+	defer cancel()
+	socket, err := s.c.GetSocket(ctx, host, socketId)
+	if err != nil {
+		s.renderPage(c, "socket", host, nil, err)
+		return
+	}
+	s.renderPage(c, "socket", host, gin.H{"Socket": socket, "Decoded": grpc.SocketResult{Socket: socket}.Decode()}, nil)
+}
+
+func (s *ChannelzProxyRoutes) uiServersRoute(c *gin.Context) {
+	host, _, ok := s.uiQuery(c, "")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*5))
+	defer cancel()
+	servers, _, err := s.c.GetServers(ctx, host, 0, 0, 0)
+	s.renderPage(c, "servers", host, servers, err)
+}
+
+func (s *ChannelzProxyRoutes) uiServerRoute(c *gin.Context) {
+	host, serverId, ok := s.uiQuery(c, "serverId")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(upstreamContext(c), s.targetTimeout(host, time.Second*20))
+	defer cancel()
+	server, err := s.c.GetServer(ctx, host, serverId)
+	if err != nil {
+		s.renderPage(c, "server", host, nil, err)
+		return
+	}
+	serverNodes, err := s.c.ExpandServers(ctx, host, []*channelzgrpc.Server{server}, grpc.Expand{Sockets: true})
+	if err != nil {
+		s.renderPage(c, "server", host, nil, err)
+		return
+	}
+	s.renderPage(c, "server", host, serverNodes[0], nil)
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/discovery"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	ggrpc "google.golang.org/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var readyState = &channelzgrpc.ChannelConnectivityState{State: channelzgrpc.ChannelConnectivityState_READY}
+
+// uiChannelzServer serves channel 1 with subchannel 2, which has the sockets 3 and the missing 4,
+// subchannel 7 with only the missing socket 4 and server 5 listening on socket 6 with the socket 3
+type uiChannelzServer struct {
+	channelzgrpc.UnimplementedChannelzServer
+}
+
+func (s *uiChannelzServer) channel() *channelzgrpc.Channel {
+	return &channelzgrpc.Channel{
+		Ref:           &channelzgrpc.ChannelRef{ChannelId: 1},
+		Data:          &channelzgrpc.ChannelData{Target: "dns:///api.svc:9000", State: readyState, CallsStarted: 7},
+		SubchannelRef: []*channelzgrpc.SubchannelRef{{SubchannelId: 2}},
+	}
+}
+
+func (s *uiChannelzServer) GetTopChannels(ctx context.Context, req *channelzgrpc.GetTopChannelsRequest) (*channelzgrpc.GetTopChannelsResponse, error) {
+	return &channelzgrpc.GetTopChannelsResponse{Channel: []*channelzgrpc.Channel{s.channel()}, End: true}, nil
+}
+
+func (s *uiChannelzServer) GetChannel(ctx context.Context, req *channelzgrpc.GetChannelRequest) (*channelzgrpc.GetChannelResponse, error) {
+	return &channelzgrpc.GetChannelResponse{Channel: s.channel()}, nil
+}
+
+func (s *uiChannelzServer) GetSubchannel(ctx context.Context, req *channelzgrpc.GetSubchannelRequest) (*channelzgrpc.GetSubchannelResponse, error) {
+	socketRefs := []*channelzgrpc.SocketRef{{SocketId: 3}, {SocketId: 4}}
+	if req.SubchannelId == 7 {
+		socketRefs = []*channelzgrpc.SocketRef{{SocketId: 4}}
+	}
+	return &channelzgrpc.GetSubchannelResponse{Subchannel: &channelzgrpc.Subchannel{
+		Ref:       &channelzgrpc.SubchannelRef{SubchannelId: req.SubchannelId},
+		Data:      &channelzgrpc.ChannelData{Target: "10.0.0.1:9000", State: readyState},
+		SocketRef: socketRefs,
+	}}, nil
+}
+
+func (s *uiChannelzServer) GetSocket(ctx context.Context, req *channelzgrpc.GetSocketRequest) (*channelzgrpc.GetSocketResponse, error) {
+	if req.SocketId == 4 {
+		return nil, status.Error(codes.NotFound, "socket 4 not found")
+	}
+	return &channelzgrpc.GetSocketResponse{Socket: &channelzgrpc.Socket{
+		Ref:  &channelzgrpc.SocketRef{SocketId: req.SocketId},
+		Data: &channelzgrpc.SocketData{StreamsStarted: 11},
+	}}, nil
+}
+
+func (s *uiChannelzServer) server() *channelzgrpc.Server {
+	return &channelzgrpc.Server{
+		Ref:          &channelzgrpc.ServerRef{ServerId: 5},
+		Data:         &channelzgrpc.ServerData{CallsStarted: 13},
+		ListenSocket: []*channelzgrpc.SocketRef{{SocketId: 6}},
+	}
+}
+
+func (s *uiChannelzServer) GetServers(ctx context.Context, req *channelzgrpc.GetServersRequest) (*channelzgrpc.GetServersResponse, error) {
+	return &channelzgrpc.GetServersResponse{Server: []*channelzgrpc.Server{s.server()}, End: true}, nil
+}
+
+func (s *uiChannelzServer) GetServer(ctx context.Context, req *channelzgrpc.GetServerRequest) (*channelzgrpc.GetServerResponse, error) {
+	return &channelzgrpc.GetServerResponse{Server: s.server()}, nil
+}
+
+func (s *uiChannelzServer) GetServerSockets(ctx context.Context, req *channelzgrpc.GetServerSocketsRequest) (*channelzgrpc.GetServerSocketsResponse, error) {
+	return &channelzgrpc.GetServerSocketsResponse{SocketRef: []*channelzgrpc.SocketRef{{SocketId: 3}}, End: true}, nil
+}
+
+func TestUiPages(t *testing.T) {
+	host := grpc.ServeTestServer(t, func(s ggrpc.ServiceRegistrar) {
+		channelzgrpc.RegisterChannelzServer(s, &uiChannelzServer{})
+	})
+	router := testRouter(t, grpc.NewChannelzProxyServer(zap.NewNop()), ServerConfig{})
+
+	for path, expected := range map[string][]string{
+		"/ui/channels?host=" + host:                  {"dns:///api.svc:9000", "channelId=1"},
+		"/ui/channel?channelId=1&host=" + host:       {"Channel 1", "dns:///api.svc:9000", "subchannelId=2"},
+		"/ui/subchannel?subchannelId=2&host=" + host: {"Subchannel 2", "socketId=3", "Entity 4: NotFound socket 4 not found"},
+		"/ui/subchannel?subchannelId=7&host=" + host: {"Subchannel 7", "10.0.0.1:9000", "Entity 4: NotFound socket 4 not found"},
+		"/ui/socket?socketId=3&host=" + host:         {"Socket 3", "11 started"},
+		"/ui/servers?host=" + host:                   {"serverId=5"},
+		"/ui/server?serverId=5&host=" + host:         {"Server 5", "13 started", "socketId=6", "socketId=3"},
+	} {
+		res := doRequest(router, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusOK, res.Code, path)
+		assert.Equal(t, "text/html; charset=utf-8", res.Header().Get("Content-Type"), path)
+		for _, content := range expected {
+			assert.Contains(t, res.Body.String(), content, path)
+		}
+	}
+
+	// The hosts page lists the connection opened by the other pages
+	res := doRequest(router, http.MethodGet, "/ui/", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), ">"+host+"</a>")
+}
+
+func TestUiPageErrors(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{})
+	host := createTestEmptyServer(t)
+
+	res := doRequest(router, http.MethodGet, "/ui/channel?channelId=1&host="+host, nil)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Contains(t, res.Body.String(), "Unimplemented")
+
+	res = doRequest(router, http.MethodGet, "/ui/channel?channelId=a&host="+host, nil)
+	assert.Contains(t, res.Body.String(), "channelId should be an int")
+
+	res = doRequest(router, http.MethodGet, "/ui/channels", nil)
+	assert.Equal(t, http.StatusFound, res.Code)
+}
+
+// slowChannelzServer answers the top channels when the call is cancelled
+type slowChannelzServer struct {
+	uiChannelzServer
+}
+
+func (s *slowChannelzServer) GetTopChannels(ctx context.Context, req *channelzgrpc.GetTopChannelsRequest) (*channelzgrpc.GetTopChannelsResponse, error) {
+	<-ctx.Done()
+	return nil, status.FromContextError(ctx.Err()).Err()
+}
+
+func TestUiTargetTimeout(t *testing.T) {
+	address := grpc.ServeTestServer(t, func(s ggrpc.ServiceRegistrar) {
+		channelzgrpc.RegisterChannelzServer(s, &slowChannelzServer{})
+	})
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("targets:\n  - name: slow\n    address: "+address+"\n    timeout: 50ms\n"), 0600))
+	c := grpc.NewChannelzProxyServer(zap.NewNop())
+	registry := discovery.NewRegistry(zap.NewNop(), c, path)
+	require.NoError(t, registry.Reload())
+	c.AddHostResolver(registry.Resolve)
+	router := testRouter(t, c, ServerConfig{Registry: registry})
+
+	start := time.Now()
+	res := doRequest(router, http.MethodGet, "/ui/channels?host=slow", nil)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Contains(t, res.Body.String(), "DeadlineExceeded")
+}
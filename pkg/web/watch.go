@@ -0,0 +1,284 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/util"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+const minWatchInterval = 500 * time.Millisecond
+
+type watchKey struct {
+	host     string
+	kind     string
+	id       int64
+	interval time.Duration
+}
+
+// watchEvent is pushed to subscribers when the watched entity changed
+type watchEvent struct {
+	Time    time.Time `json:"time"`
+	Changes []string  `json:"changes,omitempty"`
+	// Resync is set on the first event received after events were dropped for a slow subscriber,
+	// Data is the current entity but the changes of the dropped events are lost
+	Resync bool        `json:"resync,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  gin.H       `json:"error,omitempty"`
+}
+
+// watchState is the part of an entity compared between two polls
+type watchState struct {
+	state          channelzgrpc.ChannelConnectivityState_State
+	callsStarted   int64
+	callsSucceeded int64
+	callsFailed    int64
+	eventsLogged   int64
+	subchannelIds  []int64
+}
+
+func newWatchState(data interface {
+	GetState() *channelzgrpc.ChannelConnectivityState
+	GetCallsStarted() int64
+	GetCallsSucceeded() int64
+	GetCallsFailed() int64
+	GetTrace() *channelzgrpc.ChannelTrace
+}, subchannelRefs []*channelzgrpc.SubchannelRef) watchState {
+	subchannelIds := make([]int64, 0, len(subchannelRefs))
+	for _, subchannelRef := range subchannelRefs {
+		subchannelIds = append(subchannelIds, subchannelRef.GetSubchannelId())
+	}
+	sort.Slice(subchannelIds, func(i, j int) bool { return subchannelIds[i] < subchannelIds[j] })
+	return watchState{
+		state:          data.GetState().GetState(),
+		callsStarted:   data.GetCallsStarted(),
+		callsSucceeded: data.GetCallsSucceeded(),
+		callsFailed:    data.GetCallsFailed(),
+		eventsLogged:   data.GetTrace().GetNumEventsLogged(),
+		subchannelIds:  subchannelIds,
+	}
+}
+
+// changes lists what changed between two polls of the same entity
+func (w watchState) changes(previous watchState) []string {
+	changes := make([]string, 0)
+	if w.state != previous.state {
+		changes = append(changes, "state")
+	}
+	if w.callsStarted != previous.callsStarted || w.callsSucceeded != previous.callsSucceeded || w.callsFailed != previous.callsFailed {
+		changes = append(changes, "counters")
+	}
+	if w.eventsLogged != previous.eventsLogged {
+		changes = append(changes, "trace")
+	}
+	if len(w.subchannelIds) != len(previous.subchannelIds) {
+		changes = append(changes, "subchannels")
+	} else {
+		for i := range w.subchannelIds {
+			if w.subchannelIds[i] != previous.subchannelIds[i] {
+				changes = append(changes, "subchannels")
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// watcher polls one entity and broadcasts changes to its subscribers.
+// Subscribers are mapped to whether they missed an event.
+type watcher struct {
+	subscribers map[chan watchEvent]bool
+	last        *watchEvent
+	cancel      context.CancelFunc
+}
+
+// watchHub shares a single upstream poll between all subscribers watching the same entity
+type watchHub struct {
+	c      *grpc.ChannelzProxyServer
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	watchers map[watchKey]*watcher
+}
+
+func newWatchHub(logger *zap.Logger, c *grpc.ChannelzProxyServer) *watchHub {
+	return &watchHub{
+		c:        c,
+		logger:   logger.Named("WatchHub"),
+		watchers: make(map[watchKey]*watcher),
+	}
+}
+
+// subscribe returns a channel receiving the changes of the entity and a function to unsubscribe
+func (h *watchHub) subscribe(key watchKey) (chan watchEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := make(chan watchEvent, 10)
+	w, ok := h.watchers[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		w = &watcher{subscribers: make(map[chan watchEvent]bool), cancel: cancel}
+		h.watchers[key] = w
+		go h.poll(ctx, key, w)
+	} else if w.last != nil {
+		events <- *w.last
+	}
+	w.subscribers[events] = false
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(w.subscribers, events)
+		if len(w.subscribers) == 0 {
+			w.cancel()
+			delete(h.watchers, key)
+		}
+	}
+	return events, unsubscribe
+}
+
+func (h *watchHub) broadcast(w *watcher, event watchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.last = &event
+	for subscriber, missed := range w.subscribers {
+		subscriberEvent := event
+		subscriberEvent.Resync = missed
+		select {
+		case subscriber <- subscriberEvent:
+			w.subscribers[subscriber] = false
+		default:
+			// Slow subscriber, the next event it receives is flagged as a resync
+			w.subscribers[subscriber] = true
+		}
+	}
+}
+
+func (h *watchHub) fetch(ctx context.Context, key watchKey) (interface{}, watchState, error) {
+	switch key.kind {
+	case "channel":
+		channel, err := h.c.GetChannel(ctx, key.host, key.id)
+		if err != nil {
+			return nil, watchState{}, err
+		}
+		return channel, newWatchState(channel.GetData(), channel.GetSubchannelRef()), nil
+	case "subchannel":
+		subchannel, err := h.c.GetSubchannel(ctx, key.host, key.id)
+		if err != nil {
+			return nil, watchState{}, err
+		}
+		return subchannel, newWatchState(subchannel.GetData(), subchannel.GetSubchannelRef()), nil
+	default:
+		server, err := h.c.GetServer(ctx, key.host, key.id)
+		if err != nil {
+			return nil, watchState{}, err
+		}
+		data := server.GetData()
+		return server, watchState{
+			callsStarted:   data.GetCallsStarted(),
+			callsSucceeded: data.GetCallsSucceeded(),
+			callsFailed:    data.GetCallsFailed(),
+			eventsLogged:   data.GetTrace().GetNumEventsLogged(),
+		}, nil
+	}
+}
+
+func (h *watchHub) poll(ctx context.Context, key watchKey, w *watcher) {
+	ticker := time.NewTicker(key.interval)
+	defer ticker.Stop()
+	var previous *watchState
+	var previousErr string
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, key.interval+5*time.Second)
+		data, state, err := h.fetch(fetchCtx, key)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			if err.Error() != previousErr {
+				h.logger.Warn("Error polling watched entity", zap.String("host", key.host), zap.Error(err))
+				h.broadcast(w, watchEvent{Time: time.Now(), Error: util.FormatGrpcError(err)})
+			}
+			previousErr = err.Error()
+		case previous == nil:
+			h.broadcast(w, watchEvent{Time: time.Now(), Changes: []string{"initial"}, Data: data})
+			previous = &state
+			previousErr = ""
+		default:
+			changes := state.changes(*previous)
+			if len(changes) > 0 || previousErr != "" {
+				h.broadcast(w, watchEvent{Time: time.Now(), Changes: changes, Data: data})
+			}
+			previous = &state
+			previousErr = ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchRoute streams the changes of a channel, subchannel or server as server-sent events
+func (s *ChannelzProxyRoutes) watchRoute(c *gin.Context) {
+	host, err := s.getHost(c)
+	if err != nil {
+		return
+	}
+	key := watchKey{host: host, interval: s.targetPollInterval(host, s.config.WatchInterval)}
+	for _, kind := range []string{"channel", "subchannel", "server"} {
+		if _, ok := c.GetQuery(kind + "Id"); ok {
+			if key.kind != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Only one of channelId, subchannelId or serverId can be set"})
+				return
+			}
+			key.kind = kind
+			key.id, err = s.getIntQuery(c, kind+"Id", "0")
+			if err != nil {
+				return
+			}
+		}
+	}
+	if key.kind == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Missing channelId, subchannelId or serverId parameter"})
+		return
+	}
+	if intervalQuery, ok := c.GetQuery("interval"); ok {
+		key.interval, err = time.ParseDuration(intervalQuery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "interval should be a duration",
+				"details": err.Error()})
+			return
+		}
+	}
+	if key.interval < minWatchInterval {
+		key.interval = minWatchInterval
+	}
+
+	events, unsubscribe := s.watchHub.subscribe(key)
+	defer unsubscribe()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event := <-events:
+			name := "update"
+			if event.Error != nil {
+				name = "error"
+			}
+			c.SSEvent(name, event)
+			return true
+		}
+	})
+}
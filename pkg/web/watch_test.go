@@ -0,0 +1,116 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bonnefoa/channelz/channelz-proxy/pkg/grpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	ggrpc "google.golang.org/grpc"
+	channelzgrpc "google.golang.org/grpc/channelz/grpc_channelz_v1"
+)
+
+// countingChannelServer serves a single channel and counts the upstream polls
+type countingChannelServer struct {
+	channelzgrpc.UnimplementedChannelzServer
+	calls int64
+}
+
+func (s *countingChannelServer) GetChannel(ctx context.Context, req *channelzgrpc.GetChannelRequest) (*channelzgrpc.GetChannelResponse, error) {
+	atomic.AddInt64(&s.calls, 1)
+	return &channelzgrpc.GetChannelResponse{Channel: &channelzgrpc.Channel{
+		Ref:  &channelzgrpc.ChannelRef{ChannelId: req.ChannelId},
+		Data: &channelzgrpc.ChannelData{State: &channelzgrpc.ChannelConnectivityState{State: channelzgrpc.ChannelConnectivityState_READY}},
+	}}, nil
+}
+
+func receiveEvent(t *testing.T, events chan watchEvent) watchEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no watch event received")
+		return watchEvent{}
+	}
+}
+
+func TestWatchStateChanges(t *testing.T) {
+	previous := watchState{
+		state:         channelzgrpc.ChannelConnectivityState_READY,
+		callsStarted:  2,
+		eventsLogged:  3,
+		subchannelIds: []int64{4, 5},
+	}
+	assert.Empty(t, previous.changes(previous))
+
+	current := previous
+	current.state = channelzgrpc.ChannelConnectivityState_TRANSIENT_FAILURE
+	current.callsFailed = 1
+	current.eventsLogged = 4
+	current.subchannelIds = []int64{4, 6}
+	assert.Equal(t, []string{"state", "counters", "trace", "subchannels"}, current.changes(previous))
+
+	current = previous
+	current.subchannelIds = []int64{4}
+	assert.Equal(t, []string{"subchannels"}, current.changes(previous))
+}
+
+func TestWatchHubSharedPoll(t *testing.T) {
+	upstream := &countingChannelServer{}
+	address := grpc.ServeTestServer(t, func(s ggrpc.ServiceRegistrar) {
+		channelzgrpc.RegisterChannelzServer(s, upstream)
+	})
+
+	hub := newWatchHub(zap.NewNop(), grpc.NewChannelzProxyServer(zap.NewNop()))
+	key := watchKey{host: address, kind: "channel", id: 1, interval: time.Hour}
+	first, unsubscribeFirst := hub.subscribe(key)
+	event := receiveEvent(t, first)
+	assert.Equal(t, []string{"initial"}, event.Changes)
+
+	// The second subscriber gets the last event without polling the upstream again
+	second, unsubscribeSecond := hub.subscribe(key)
+	assert.Equal(t, event, receiveEvent(t, second))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&upstream.calls))
+
+	unsubscribeFirst()
+	hub.mu.Lock()
+	assert.Len(t, hub.watchers, 1)
+	hub.mu.Unlock()
+	unsubscribeSecond()
+	hub.mu.Lock()
+	assert.Empty(t, hub.watchers)
+	hub.mu.Unlock()
+}
+
+func TestWatchHubSlowSubscriber(t *testing.T) {
+	hub := newWatchHub(zap.NewNop(), grpc.NewChannelzProxyServer(zap.NewNop()))
+	events := make(chan watchEvent, 1)
+	w := &watcher{subscribers: map[chan watchEvent]bool{events: false}}
+
+	hub.broadcast(w, watchEvent{Changes: []string{"initial"}})
+	hub.broadcast(w, watchEvent{Changes: []string{"state"}})
+	assert.Equal(t, watchEvent{Changes: []string{"initial"}}, <-events)
+
+	hub.broadcast(w, watchEvent{Changes: []string{"counters"}})
+	assert.Equal(t, watchEvent{Changes: []string{"counters"}, Resync: true}, <-events)
+	hub.broadcast(w, watchEvent{Changes: []string{"trace"}})
+	assert.Equal(t, watchEvent{Changes: []string{"trace"}}, <-events)
+}
+
+func TestWatchRouteParameters(t *testing.T) {
+	router := testRouter(t, nil, ServerConfig{})
+	for _, path := range []string{
+		"/api/watch?host=localhost:1",
+		"/api/watch?host=localhost:1&channelId=1&serverId=2",
+		"/api/watch?host=localhost:1&subchannelId=1&channelId=2",
+		"/api/watch?host=localhost:1&channelId=a",
+		"/api/watch?host=localhost:1&channelId=1&interval=1",
+	} {
+		assert.Equal(t, http.StatusBadRequest, doRequest(router, http.MethodGet, path, nil).Code, path)
+	}
+}